@@ -0,0 +1,158 @@
+package leaf
+
+import (
+	"testing"
+	"time"
+
+	"github.com/YiuTerran/leaf/chanrpc"
+	"github.com/YiuTerran/leaf/event"
+	"github.com/YiuTerran/leaf/log"
+	"github.com/YiuTerran/leaf/module"
+)
+
+type testModule struct {
+	name string
+}
+
+func (m *testModule) Name() string               { return m.name }
+func (m *testModule) Version() string            { return "1" }
+func (m *testModule) OnInit()                    {}
+func (m *testModule) OnDestroy()                 {}
+func (m *testModule) Run(closeSig chan struct{}) { <-closeSig }
+func (m *testModule) RPCServer() *chanrpc.Server { return nil }
+
+func TestApp(t *testing.T) {
+	log.InitLogger("")
+	apps := []*App{NewApp(), NewApp()}
+	errs := make(chan error, len(apps))
+	for _, a := range apps {
+		a := a
+		go func() {
+			errs <- a.AutoRun(func() []module.Module {
+				return []module.Module{&testModule{"game"}}
+			})
+		}()
+	}
+	for _, a := range apps {
+		for len(a.Modules().List()) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if err := apps[0].Run(nil); err != ErrRunning {
+		t.Fatalf("unexpected error %v", err)
+	}
+	for _, a := range apps {
+		if err := a.Shutdown(); err != nil {
+			t.Fatal(err)
+		}
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+		if len(a.Modules().List()) != 0 {
+			t.Fatal("expected no module")
+		}
+	}
+	if err := apps[0].Shutdown(); err != ErrNotRunning {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	//restart in process
+	go func() {
+		errs <- apps[0].StaticRun([]module.Module{&testModule{"game"}})
+	}()
+	for len(apps[0].Modules().List()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := apps[0].Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+}
+
+type skeletonModule struct {
+	*module.Skeleton
+	events chan string
+}
+
+func newSkeletonModule() *skeletonModule {
+	s := &module.Skeleton{Name: "game", GoLen: 10, TimerDispatcherLen: 10, AsyncCallLen: 10,
+		ChanRPCServer: chanrpc.NewServer(10)}
+	s.Init()
+	return &skeletonModule{Skeleton: s, events: make(chan string, 10)}
+}
+
+func (m *skeletonModule) Name() string               { return "game" }
+func (m *skeletonModule) Version() string            { return "1" }
+func (m *skeletonModule) OnDestroy()                 {}
+func (m *skeletonModule) RPCServer() *chanrpc.Server { return m.ChanRPCServer }
+
+func (m *skeletonModule) OnInit() {
+	m.Subscribe("login", func(args []interface{}) {
+		m.events <- args[0].(string)
+	})
+}
+
+func TestAppIsolation(t *testing.T) {
+	log.InitLogger("")
+	apps := []*App{NewApp(), NewApp()}
+	mods := []*skeletonModule{newSkeletonModule(), newSkeletonModule()}
+	errs := make(chan error, len(apps))
+	for i, a := range apps {
+		m := mods[i]
+		a := a
+		go func() {
+			errs <- a.StaticRun([]module.Module{m})
+		}()
+	}
+	for _, a := range apps {
+		for len(a.Modules().LoopStats()) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	for i, a := range apps {
+		//同名的Skeleton注册在各自的console和管理器中
+		if s := a.Console().Servers().Servers()["game"]; s != mods[i].ChanRPCServer {
+			t.Fatalf("app %v: unexpected rpcstat server %p", i, s)
+		}
+		if stats := a.Modules().LoopStats(); len(stats) != 1 {
+			t.Fatalf("app %v: unexpected loop stats %v", i, stats)
+		}
+		if a.Logger() == log.Default() || a.Logger() == apps[1-i].Logger() {
+			t.Fatalf("app %v: expected its own logger", i)
+		}
+	}
+	if _, ok := chanrpc.Servers()["game"]; ok {
+		t.Fatal("unexpected server in the default registry")
+	}
+	if len(module.LoopStats()) != 0 {
+		t.Fatal("unexpected skeleton in the default manager")
+	}
+
+	//事件只发布到同一个App的模块
+	if n := apps[0].Modules().Bus().Publish("login", "alice"); n != 1 {
+		t.Fatalf("expected 1 subscriber, got %v", n)
+	}
+	if n := event.Publish("login", "bob"); n != 0 {
+		t.Fatalf("unexpected subscribers of the default bus %v", n)
+	}
+	if name := <-mods[0].events; name != "alice" {
+		t.Fatalf("unexpected event %v", name)
+	}
+
+	for _, a := range apps {
+		if err := a.Shutdown(); err != nil {
+			t.Fatal(err)
+		}
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case name := <-mods[1].events:
+		t.Fatalf("unexpected event %v", name)
+	default:
+	}
+}
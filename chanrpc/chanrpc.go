@@ -1,9 +1,12 @@
 package chanrpc
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"runtime"
+	"sync"
+	"time"
 
 	"github.com/YiuTerran/leaf/log"
 )
@@ -17,18 +20,38 @@ type Server struct {
 	// func(args []interface{})
 	// func(args []interface{}) interface{}
 	// func(args []interface{}) []interface{}
-	functions map[interface{}]interface{}
-	ChanCall  chan *CallInfo
+	// *typedFunc
+	functions    map[interface{}]interface{}
+	mutexFuncs   sync.RWMutex // functions and priority
+	ChanCall     chan *CallInfo
+	interceptors []Interceptor
+	stats        *stats
+	priority     map[interface{}]Priority
+	lanes        [numPriority]chan *CallInfo
+	closed       bool
+	mutexLanes   sync.RWMutex // lanes, ChanCall and closed
+
+	overflow        OverflowPolicy
+	overflowTimeout time.Duration
+	recorder        func(ci *CallInfo)
+
+	name string
 }
 
 type CallInfo struct {
+	id      interface{}
 	f       interface{}
 	args    []interface{}
 	chanRet chan *RetInfo
 	cb      interface{}
+	future  *Future
+	// caller may give up, skip the call if done
+	ctx      context.Context
+	priority Priority
 }
 
 type RetInfo struct {
+	id interface{}
 	// nil
 	// interface{}
 	// []interface{}
@@ -46,12 +69,22 @@ type Client struct {
 	chanSyncRet      chan *RetInfo
 	ChanAsyncRet     chan *RetInfo
 	pendingAsyncCall int
+	cbInterceptors   []CbInterceptor
 }
 
 func NewServer(l int) *Server {
 	s := new(Server)
 	s.functions = make(map[interface{}]interface{})
 	s.ChanCall = make(chan *CallInfo, l)
+	s.stats = newStats()
+	s.priority = make(map[interface{}]Priority)
+	s.lanes[PriorityNormal] = s.ChanCall
+	if l > PriorityLen {
+		l = PriorityLen
+	}
+	for p := PriorityNormal + 1; p < numPriority; p++ {
+		s.lanes[p] = make(chan *CallInfo, l)
+	}
 	return s
 }
 
@@ -63,8 +96,7 @@ func assert(i interface{}) []interface{} {
 	}
 }
 
-// you must call the function before calling Open and Go
-func (s *Server) Register(id interface{}, f interface{}) {
+func checkFunc(id interface{}, f interface{}) {
 	switch f.(type) {
 	case func([]interface{}):
 	case func([]interface{}) interface{}:
@@ -72,15 +104,55 @@ func (s *Server) Register(id interface{}, f interface{}) {
 	default:
 		panic(fmt.Sprintf("function id %v: definition of function is invalid", id))
 	}
+}
+
+// goroutine safe
+func (s *Server) Register(id interface{}, f interface{}) {
+	checkFunc(id, f)
+	s.set(id, f, false)
+}
+
+// register or replace the function, calls already in the channel run the old one
+// goroutine safe
+func (s *Server) Replace(id interface{}, f interface{}) {
+	checkFunc(id, f)
+	s.set(id, f, true)
+}
+
+// return false if the function is not registered
+// goroutine safe
+func (s *Server) Unregister(id interface{}) bool {
+	s.mutexFuncs.Lock()
+	defer s.mutexFuncs.Unlock()
+	if _, ok := s.functions[id]; !ok {
+		return false
+	}
+	delete(s.functions, id)
+	delete(s.priority, id)
+	return true
+}
 
-	if _, ok := s.functions[id]; ok {
+func (s *Server) set(id interface{}, f interface{}, replace bool) {
+	s.mutexFuncs.Lock()
+	defer s.mutexFuncs.Unlock()
+	if _, ok := s.functions[id]; ok && !replace {
 		panic(fmt.Sprintf("function id %v: already registered", id))
 	}
 
 	s.functions[id] = f
 }
 
+func (s *Server) function(id interface{}) (interface{}, Priority) {
+	s.mutexFuncs.RLock()
+	defer s.mutexFuncs.RUnlock()
+	return s.functions[id], s.priority[id]
+}
+
 func (s *Server) ret(ci *CallInfo, ri *RetInfo) (err error) {
+	if ci.future != nil {
+		ci.future.resolve(ri.ret, ri.err)
+		return
+	}
 	if ci.chanRet == nil {
 		return
 	}
@@ -91,6 +163,7 @@ func (s *Server) ret(ci *CallInfo, ri *RetInfo) (err error) {
 		}
 	}()
 
+	ri.id = ci.id
 	ri.cb = ci.cb
 	ci.chanRet <- ri
 	return
@@ -106,22 +179,120 @@ func (s *Server) exec(ci *CallInfo) (err error) {
 		}
 	}()
 
+	// caller has given up
+	if ci.ctx != nil && ci.ctx.Err() != nil {
+		return s.ret(ci, &RetInfo{err: ci.ctx.Err()})
+	}
+
+	if s.recorder != nil {
+		s.recorder(ci)
+	}
+	if debug.Load() {
+		defer enterExec(s, ci.id)()
+	}
+
+	start := time.Now()
+	failed := true
+	defer func() {
+		s.stats.record(ci.id, time.Since(start), failed)
+	}()
+
 	// execute
-	switch ci.f.(type) {
+	ri := new(RetInfo)
+	if len(interceptors) == 0 && len(s.interceptors) == 0 {
+		ri.ret, ri.err = call(ci.f, ci.args)
+	} else {
+		h := func(id interface{}, args []interface{}) (interface{}, error) {
+			return call(ci.f, args)
+		}
+		ri.ret, ri.err = chain(h, interceptors, s.interceptors)(ci.id, ci.args)
+	}
+	failed = ri.err != nil
+	if ri.err != nil && ci.chanRet == nil && ci.future == nil {
+		return fmt.Errorf("function id %v: %v", ci.id, ri.err)
+	}
+	return s.ret(ci, ri)
+}
+
+func call(f interface{}, args []interface{}) (interface{}, error) {
+	switch f.(type) {
 	case func([]interface{}):
-		ci.f.(func([]interface{}))(ci.args)
-		return s.ret(ci, &RetInfo{})
+		f.(func([]interface{}))(args)
+		return nil, nil
 	case func([]interface{}) interface{}:
-		ret := ci.f.(func([]interface{}) interface{})(ci.args)
-		return s.ret(ci, &RetInfo{ret: ret})
+		return f.(func([]interface{}) interface{})(args), nil
 	case func([]interface{}) []interface{}:
-		ret := ci.f.(func([]interface{}) []interface{})(ci.args)
-		return s.ret(ci, &RetInfo{ret: ret})
+		return f.(func([]interface{}) []interface{})(args), nil
+	case *typedFunc:
+		ri := f.(*typedFunc).call(args)
+		return ri.ret, ri.err
 	}
 
 	panic("bug")
 }
 
+func (ci *CallInfo) ID() interface{} {
+	return ci.id
+}
+
+// the function id of the call the result belongs to
+func (ri *RetInfo) ID() interface{} {
+	return ri.id
+}
+
+func (ci *CallInfo) Args() []interface{} {
+	return ci.args
+}
+
+// how the call was made: go, call, async or future
+func (ci *CallInfo) Source() string {
+	switch {
+	case ci.future != nil:
+		return "future"
+	case ci.cb != nil:
+		return "async"
+	case ci.chanRet != nil:
+		return "call"
+	default:
+		return "go"
+	}
+}
+
+// f is called with every call before it is executed, in the goroutine of the server
+// you must call the function before calling Open and Go
+func (s *Server) SetRecorder(f func(ci *CallInfo)) {
+	s.recorder = f
+}
+
+// execute the function in the current goroutine, which must be the goroutine of the server
+func (s *Server) Invoke(id interface{}, args ...interface{}) (interface{}, error) {
+	f, p := s.function(id)
+	if f == nil {
+		return nil, fmt.Errorf("function id %v: function not registered", id)
+	}
+
+	future := newFuture()
+	s.Exec(&CallInfo{
+		id:       id,
+		f:        f,
+		args:     args,
+		future:   future,
+		priority: p,
+	})
+	return future.Wait()
+}
+
+// goroutine safe
+func (s *Server) IDs() []interface{} {
+	s.mutexFuncs.RLock()
+	defer s.mutexFuncs.RUnlock()
+	ids := make([]interface{}, 0, len(s.functions))
+	for id := range s.functions {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
 func (s *Server) Exec(ci *CallInfo) {
 	err := s.exec(ci)
 	if err != nil {
@@ -131,19 +302,31 @@ func (s *Server) Exec(ci *CallInfo) {
 
 // goroutine safe
 func (s *Server) Go(id interface{}, args ...interface{}) {
-	f := s.functions[id]
+	_ = s.goPriority(-1, id, args)
+}
+
+// p < 0: the priority of the function
+func (s *Server) goPriority(p Priority, id interface{}, args []interface{}) error {
+	f, fp := s.function(id)
+	if p < 0 {
+		p = fp
+	}
 	if f == nil {
-		return
+		s.stats.drop()
+		return fmt.Errorf("function id %v: function not registered", id)
 	}
 
-	defer func() {
-		recover()
-	}()
-
-	s.ChanCall <- &CallInfo{
-		f:    f,
-		args: args,
+	err := s.enqueue(context.Background(), &CallInfo{
+		id:       id,
+		f:        f,
+		args:     args,
+		priority: p,
+	}, true)
+	if err != nil && err != ErrChannelFull && err != ErrDropped {
+		// server closed
+		s.stats.drop()
 	}
+	return err
 }
 
 // goroutine safe
@@ -161,14 +344,59 @@ func (s *Server) CallN(id interface{}, args ...interface{}) ([]interface{}, erro
 	return s.Open(0).CallN(id, args...)
 }
 
+// goroutine safe
+func (s *Server) Call0Context(ctx context.Context, id interface{}, args ...interface{}) error {
+	return s.Open(0).Call0Context(ctx, id, args...)
+}
+
+// goroutine safe
+func (s *Server) Call1Context(ctx context.Context, id interface{}, args ...interface{}) (interface{}, error) {
+	return s.Open(0).Call1Context(ctx, id, args...)
+}
+
+// goroutine safe
+func (s *Server) CallNContext(ctx context.Context, id interface{}, args ...interface{}) ([]interface{}, error) {
+	return s.Open(0).CallNContext(ctx, id, args...)
+}
+
 func (s *Server) Close() {
-	close(s.ChanCall)
+	leaveServer(s)
+	s.mutexLanes.Lock()
+	if s.closed {
+		s.mutexLanes.Unlock()
+		return
+	}
+	s.closed = true
+	lanes := s.lanes
+	for _, lane := range lanes {
+		close(lane)
+	}
+	s.mutexLanes.Unlock()
+
+	for _, lane := range lanes {
+		for ci := range lane {
+			s.stats.drop()
+			_ = s.ret(ci, &RetInfo{
+				err: errors.New("chanrpc server closed"),
+			})
+		}
+	}
+}
 
-	for ci := range s.ChanCall {
-		_ = s.ret(ci, &RetInfo{
-			err: errors.New("chanrpc server closed"),
-		})
+// make a closed server accept calls again, with new channels of the same length.
+// the registered functions are kept. it must be called in the goroutine that
+// reads ChanCall and the lanes, before reading them again
+func (s *Server) Reopen() {
+	s.mutexLanes.Lock()
+	defer s.mutexLanes.Unlock()
+	if !s.closed {
+		return
+	}
+	for p := range s.lanes {
+		s.lanes[p] = make(chan *CallInfo, cap(s.lanes[p]))
 	}
+	s.ChanCall = s.lanes[PriorityNormal]
+	s.closed = false
 }
 
 // goroutine safe
@@ -189,37 +417,25 @@ func (c *Client) Attach(s *Server) {
 	c.s = s
 }
 
-func (c *Client) call(ci *CallInfo, block bool) (err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			err = r.(error)
-		}
-	}()
-
-	if block {
-		c.s.ChanCall <- ci
-	} else {
-		select {
-		case c.s.ChanCall <- ci:
-		default:
-			err = errors.New("chanrpc channel full")
-		}
-	}
-	return
-}
-
 func (c *Client) f(id interface{}, n int) (f interface{}, err error) {
 	if c.s == nil {
 		err = errors.New("server not attached")
 		return
 	}
 
-	f = c.s.functions[id]
+	f, _ = c.s.function(id)
 	if f == nil {
 		err = fmt.Errorf("function id %v: function not registered", id)
 		return
 	}
 
+	if t, ok := f.(*typedFunc); ok {
+		if t.kind() != n {
+			err = fmt.Errorf("function id %v: return type mismatch", id)
+		}
+		return
+	}
+
 	var ok bool
 	switch n {
 	case 0:
@@ -238,78 +454,100 @@ func (c *Client) f(id interface{}, n int) (f interface{}, err error) {
 	return
 }
 
-func (c *Client) Call0(id interface{}, args ...interface{}) error {
-	f, err := c.f(id, 0)
-	if err != nil {
-		return err
-	}
-
-	err = c.call(&CallInfo{
-		f:       f,
-		args:    args,
-		chanRet: c.chanSyncRet,
-	}, true)
-	if err != nil {
-		return err
+// wait for the result, abort if ctx is done
+func (c *Client) waitContext(ctx context.Context) (*RetInfo, error) {
+	select {
+	case ri := <-c.chanSyncRet:
+		return ri, nil
+	case <-ctx.Done():
+		// the late result goes to the abandoned channel
+		c.chanSyncRet = make(chan *RetInfo, 1)
+		return nil, ctx.Err()
 	}
-
-	ri := <-c.chanSyncRet
-	return ri.err
 }
 
-func (c *Client) Call1(id interface{}, args ...interface{}) (interface{}, error) {
-	f, err := c.f(id, 1)
+func (c *Client) syncCall(ctx context.Context, id interface{}, args []interface{}, n int) (*RetInfo, error) {
+	f, err := c.f(id, n)
 	if err != nil {
 		return nil, err
 	}
 
-	err = c.call(&CallInfo{
-		f:       f,
-		args:    args,
-		chanRet: c.chanSyncRet,
+	if debug.Load() {
+		leave, err := enterWait(c.s, id)
+		if err != nil {
+			return nil, err
+		}
+		defer leave()
+	}
+
+	err = c.s.enqueue(ctx, &CallInfo{
+		id:       id,
+		f:        f,
+		args:     args,
+		chanRet:  c.chanSyncRet,
+		ctx:      ctx,
+		priority: c.s.priorityOf(ctx, id),
 	}, true)
 	if err != nil {
 		return nil, err
 	}
 
-	ri := <-c.chanSyncRet
-	return ri.ret, ri.err
+	return c.waitContext(ctx)
+}
+
+func (c *Client) Call0(id interface{}, args ...interface{}) error {
+	return c.Call0Context(context.Background(), id, args...)
+}
+
+func (c *Client) Call1(id interface{}, args ...interface{}) (interface{}, error) {
+	return c.Call1Context(context.Background(), id, args...)
 }
 
 func (c *Client) CallN(id interface{}, args ...interface{}) ([]interface{}, error) {
-	f, err := c.f(id, 2)
+	return c.CallNContext(context.Background(), id, args...)
+}
+
+func (c *Client) Call0Context(ctx context.Context, id interface{}, args ...interface{}) error {
+	ri, err := c.syncCall(ctx, id, args, 0)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	return ri.err
+}
 
-	err = c.call(&CallInfo{
-		f:       f,
-		args:    args,
-		chanRet: c.chanSyncRet,
-	}, true)
+func (c *Client) Call1Context(ctx context.Context, id interface{}, args ...interface{}) (interface{}, error) {
+	ri, err := c.syncCall(ctx, id, args, 1)
 	if err != nil {
 		return nil, err
 	}
+	return ri.ret, ri.err
+}
 
-	ri := <-c.chanSyncRet
+func (c *Client) CallNContext(ctx context.Context, id interface{}, args ...interface{}) ([]interface{}, error) {
+	ri, err := c.syncCall(ctx, id, args, 2)
+	if err != nil {
+		return nil, err
+	}
 	return assert(ri.ret), ri.err
 }
 
 func (c *Client) asyncCall(id interface{}, args []interface{}, cb interface{}, n int) {
 	f, err := c.f(id, n)
 	if err != nil {
-		c.ChanAsyncRet <- &RetInfo{err: err, cb: cb}
+		c.ChanAsyncRet <- &RetInfo{id: id, err: err, cb: cb}
 		return
 	}
 
-	err = c.call(&CallInfo{
-		f:       f,
-		args:    args,
-		chanRet: c.ChanAsyncRet,
-		cb:      cb,
+	err = c.s.enqueue(context.Background(), &CallInfo{
+		id:       id,
+		f:        f,
+		args:     args,
+		chanRet:  c.ChanAsyncRet,
+		cb:       cb,
+		priority: c.s.priorityOf(nil, id),
 	}, false)
 	if err != nil {
-		c.ChanAsyncRet <- &RetInfo{err: err, cb: cb}
+		c.ChanAsyncRet <- &RetInfo{id: id, err: err, cb: cb}
 		return
 	}
 }
@@ -336,7 +574,7 @@ func (c *Client) AsyncCall(id interface{}, _args ...interface{}) {
 
 	// too many calls
 	if c.pendingAsyncCall >= cap(c.ChanAsyncRet) {
-		execCb(&RetInfo{err: errors.New("too many calls"), cb: cb})
+		c.execCb(&RetInfo{id: id, err: errors.New("too many calls"), cb: cb})
 		return
 	}
 
@@ -344,7 +582,7 @@ func (c *Client) AsyncCall(id interface{}, _args ...interface{}) {
 	c.pendingAsyncCall++
 }
 
-func execCb(ri *RetInfo) {
+func (c *Client) execCb(ri *RetInfo) {
 	defer func() {
 		if r := recover(); r != nil {
 			buf := make([]byte, log.LenStackBuf)
@@ -353,23 +591,32 @@ func execCb(ri *RetInfo) {
 		}
 	}()
 
-	// execute
-	switch ri.cb.(type) {
+	if len(cbInterceptors) == 0 && len(c.cbInterceptors) == 0 {
+		execCb(ri.cb, ri.ret, ri.err)
+		return
+	}
+	h := func(id interface{}, ret interface{}, err error) {
+		execCb(ri.cb, ret, err)
+	}
+	chainCb(h, cbInterceptors, c.cbInterceptors)(ri.id, ri.ret, ri.err)
+}
+
+func execCb(cb interface{}, ret interface{}, err error) {
+	switch cb.(type) {
 	case func(error):
-		ri.cb.(func(error))(ri.err)
+		cb.(func(error))(err)
 	case func(interface{}, error):
-		ri.cb.(func(interface{}, error))(ri.ret, ri.err)
+		cb.(func(interface{}, error))(ret, err)
 	case func([]interface{}, error):
-		ri.cb.(func([]interface{}, error))(assert(ri.ret), ri.err)
+		cb.(func([]interface{}, error))(assert(ret), err)
 	default:
 		panic("bug")
 	}
-	return
 }
 
 func (c *Client) Cb(ri *RetInfo) {
 	c.pendingAsyncCall--
-	execCb(ri)
+	c.execCb(ri)
 }
 
 func (c *Client) Close() {
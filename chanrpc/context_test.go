@@ -0,0 +1,35 @@
+package chanrpc_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/YiuTerran/leaf/chanrpc"
+)
+
+func TestCallContext(t *testing.T) {
+	s := chanrpc.NewServer(1)
+	s.Register("f1", func(args []interface{}) interface{} {
+		return 1
+	})
+	c := s.Open(0)
+
+	// nobody is serving, the call must give up
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.Call1Context(ctx, "f1"); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	// the abandoned call is skipped by the server
+	s.Exec(<-s.ChanCall)
+
+	go func() {
+		s.Exec(<-s.ChanCall)
+	}()
+	ret, err := c.Call1Context(context.Background(), "f1")
+	if err != nil || ret != 1 {
+		t.Fatalf("unexpected result %v, %v", ret, err)
+	}
+}
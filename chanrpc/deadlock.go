@@ -0,0 +1,154 @@
+package chanrpc
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.uber.org/atomic"
+)
+
+// deadlock detection of synchronous calls, only in debug mode
+//
+// a goroutine executing a function of a server and calling another server
+// synchronously waits on the goroutine serving that server. the call fails
+// if the wait would close a cycle.
+
+var (
+	debug = atomic.NewBool(false)
+
+	mutexDeadlock sync.Mutex
+	// goroutine -> function being executed
+	executing = make(map[int64]frame)
+	// server -> goroutine executing its functions
+	serving = make(map[*Server]int64)
+	// goroutine -> synchronous call it waits for
+	waiting = make(map[int64]frame)
+)
+
+type frame struct {
+	server *Server
+	id     interface{}
+}
+
+func (f frame) String() string {
+	return fmt.Sprintf("%v (function id %v)", f.server.Name(), f.id)
+}
+
+// enable the deadlock detection, it costs a stack dump per call
+func EnableDebug(option bool) {
+	debug.Store(option)
+}
+
+// name of the server in errors, set by Registry.Register
+func (s *Server) Name() string {
+	if s.name != "" {
+		return s.name
+	}
+	return fmt.Sprintf("server %p", s)
+}
+
+func goroutineID() int64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	// goroutine 123 [running]:
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i > 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseInt(string(b), 10, 64)
+	return id
+}
+
+// called around exec, return a function to call after exec
+func enterExec(s *Server, id interface{}) func() {
+	gid := goroutineID()
+
+	mutexDeadlock.Lock()
+	prev, nested := executing[gid]
+	executing[gid] = frame{server: s, id: id}
+	serving[s] = gid
+	mutexDeadlock.Unlock()
+
+	return func() {
+		mutexDeadlock.Lock()
+		if nested {
+			executing[gid] = prev
+		} else {
+			delete(executing, gid)
+		}
+		mutexDeadlock.Unlock()
+	}
+}
+
+// record the current goroutine as the one serving s, so that a synchronous
+// call made outside the functions of s, such as in a timer callback, is checked too.
+// the loop of the server should call it when it starts
+func (s *Server) Serve() {
+	gid := goroutineID()
+	mutexDeadlock.Lock()
+	serving[s] = gid
+	mutexDeadlock.Unlock()
+}
+
+// called before a synchronous call, return a function to call after the call
+func enterWait(s *Server, id interface{}) (func(), error) {
+	gid := goroutineID()
+
+	mutexDeadlock.Lock()
+	defer mutexDeadlock.Unlock()
+
+	caller, ok := executing[gid]
+	if !ok {
+		// called outside exec, e.g. in a timer callback of the goroutine serving a server
+		for server, g := range serving {
+			if g == gid {
+				caller, ok = frame{server: server, id: "callback"}, true
+				break
+			}
+		}
+	}
+	if !ok {
+		// not called from a server goroutine
+		return func() {}, nil
+	}
+
+	chain := []frame{caller, {server: s, id: id}}
+	target := s
+	for len(chain) <= len(serving)+1 {
+		g, ok := serving[target]
+		if !ok {
+			break
+		}
+		if g == gid {
+			names := make([]string, len(chain))
+			for i, f := range chain {
+				names[i] = f.String()
+			}
+			return nil, fmt.Errorf("chanrpc deadlock: %v", strings.Join(names, " -> "))
+		}
+		w, ok := waiting[g]
+		if !ok {
+			break
+		}
+		chain = append(chain, w)
+		target = w.server
+	}
+
+	waiting[gid] = frame{server: s, id: id}
+	return func() {
+		mutexDeadlock.Lock()
+		delete(waiting, gid)
+		mutexDeadlock.Unlock()
+	}, nil
+}
+
+// forget the server when it is closed
+func leaveServer(s *Server) {
+	mutexDeadlock.Lock()
+	delete(serving, s)
+	mutexDeadlock.Unlock()
+}
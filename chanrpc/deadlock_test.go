@@ -0,0 +1,51 @@
+package chanrpc_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/YiuTerran/leaf/chanrpc"
+)
+
+func TestDeadlock(t *testing.T) {
+	chanrpc.EnableDebug(true)
+	defer chanrpc.EnableDebug(false)
+
+	a := chanrpc.NewServer(10)
+	b := chanrpc.NewServer(10)
+	chanrpc.RegisterServer("A", a)
+	chanrpc.RegisterServer("B", b)
+	defer chanrpc.UnregisterServer("A")
+	defer chanrpc.UnregisterServer("B")
+
+	a.Register("self", func(args []interface{}) interface{} {
+		return a.Call0("ping")
+	})
+	a.Register("ping", func(args []interface{}) {})
+	a.Register("forward", func(args []interface{}) interface{} {
+		return b.Call0("back")
+	})
+	b.RegisterTyped("back", func() error {
+		return a.Call0("ping")
+	})
+	serve := func(s *chanrpc.Server) {
+		for ci := range s.ChanCall {
+			s.Exec(ci)
+		}
+	}
+	go serve(a)
+	go serve(b)
+	defer a.Close()
+	defer b.Close()
+
+	ret, err := a.Call1("self")
+	if err != nil || ret == nil || !strings.Contains(ret.(error).Error(), "A (function id self) -> A (function id ping)") {
+		t.Fatalf("expected self-call deadlock, got %v, %v", ret, err)
+	}
+
+	ret, err = a.Call1("forward")
+	if err != nil || ret == nil || !strings.Contains(ret.(error).Error(),
+		"B (function id back) -> A (function id ping) -> B (function id back)") {
+		t.Fatalf("expected cycle deadlock, got %v, %v", ret, err)
+	}
+}
@@ -0,0 +1,129 @@
+package chanrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var ErrTimeout = errors.New("chanrpc future timeout")
+
+// the result of an asynchronous call, goroutine safe
+type Future struct {
+	once sync.Once
+	done chan struct{}
+	// nil
+	// interface{}
+	// []interface{}
+	ret interface{}
+	err error
+}
+
+func newFuture() *Future {
+	f := new(Future)
+	f.done = make(chan struct{})
+	return f
+}
+
+func (f *Future) resolve(ret interface{}, err error) {
+	f.once.Do(func() {
+		f.ret = ret
+		f.err = err
+		close(f.done)
+	})
+}
+
+// closed when the result is ready
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+func (f *Future) Wait() (interface{}, error) {
+	<-f.done
+	return f.ret, f.err
+}
+
+// return ErrTimeout if the result is not ready in d, the call is not cancelled
+func (f *Future) WaitTimeout(d time.Duration) (interface{}, error) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-f.done:
+		return f.ret, f.err
+	case <-t.C:
+		return nil, ErrTimeout
+	}
+}
+
+func (f *Future) WaitContext(ctx context.Context) (interface{}, error) {
+	select {
+	case <-f.done:
+		return f.ret, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// call a function of any return type without waiting, it fails immediately
+// like AsyncCall if the channel is full under OverflowDefault
+// goroutine safe
+func (s *Server) CallFuture(id interface{}, args ...interface{}) *Future {
+	future := newFuture()
+	f, p := s.function(id)
+	if f == nil {
+		future.resolve(nil, fmt.Errorf("function id %v: function not registered", id))
+		return future
+	}
+
+	err := s.enqueue(context.Background(), &CallInfo{
+		id:       id,
+		f:        f,
+		args:     args,
+		future:   future,
+		priority: p,
+	}, false)
+	if err != nil {
+		future.resolve(nil, err)
+	}
+	return future
+}
+
+// a future resolved when all the futures are resolved,
+// the result is []interface{} of their results, the error is the first error
+func All(fs ...*Future) *Future {
+	future := newFuture()
+	go func() {
+		ret := make([]interface{}, len(fs))
+		var err error
+		for i, f := range fs {
+			ret[i], _ = f.Wait()
+			if f.err != nil && err == nil {
+				err = f.err
+			}
+		}
+		future.resolve(ret, err)
+	}()
+	return future
+}
+
+// a future resolved with the result of the first resolved future,
+// the calls of the others are not cancelled and their results are discarded
+func Any(fs ...*Future) *Future {
+	future := newFuture()
+	if len(fs) == 0 {
+		future.resolve(nil, errors.New("no future"))
+		return future
+	}
+	for _, f := range fs {
+		go func(f *Future) {
+			select {
+			case <-f.done:
+				future.resolve(f.ret, f.err)
+			case <-future.done:
+			}
+		}(f)
+	}
+	return future
+}
@@ -0,0 +1,65 @@
+package chanrpc_test
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/YiuTerran/leaf/chanrpc"
+)
+
+func TestFuture(t *testing.T) {
+	s := chanrpc.NewServer(10)
+	s.Register("profile", func(args []interface{}) interface{} {
+		return "profile of " + args[0].(string)
+	})
+	s.Register("inventory", func(args []interface{}) []interface{} {
+		return []interface{}{"sword", "shield"}
+	})
+
+	f := s.CallFuture("profile", "leaf")
+	if _, err := f.WaitTimeout(time.Millisecond); err != chanrpc.ErrTimeout {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+	s.Exec(<-s.ChanCall)
+	if ret, err := f.Wait(); err != nil || ret != "profile of leaf" {
+		t.Fatalf("unexpected result %v, %v", ret, err)
+	}
+
+	all := chanrpc.All(s.CallFuture("profile", "leaf"), s.CallFuture("inventory"), s.CallFuture("unknown"))
+	s.Exec(<-s.ChanCall)
+	s.Exec(<-s.ChanCall)
+	ret, err := all.Wait()
+	rets := ret.([]interface{})
+	if err == nil || rets[0] != "profile of leaf" || len(rets[1].([]interface{})) != 2 {
+		t.Fatalf("unexpected result %v, %v", ret, err)
+	}
+
+	any := chanrpc.Any(s.CallFuture("profile", "a"), s.CallFuture("profile", "b"))
+	s.Exec(<-s.ChanCall)
+	if ret, err := any.Wait(); err != nil || ret != "profile of a" {
+		t.Fatalf("unexpected result %v, %v", ret, err)
+	}
+	s.Exec(<-s.ChanCall)
+}
+
+func TestAnyNoLeak(t *testing.T) {
+	s := chanrpc.NewServer(10)
+	s.Register("never", func(args []interface{}) {})
+
+	n := runtime.NumGoroutine()
+	var fs []*chanrpc.Future
+	for i := 0; i < 5; i++ {
+		fs = append(fs, s.CallFuture("never"))
+	}
+	fs = append(fs, s.CallFuture("unknown"))
+	if _, err := chanrpc.Any(fs...).Wait(); err == nil {
+		t.Fatal("expected the error of unknown")
+	}
+	for i := 0; runtime.NumGoroutine() > n; i++ {
+		if i == 100 {
+			t.Fatalf("goroutines leaked: %v > %v", runtime.NumGoroutine(), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,111 @@
+package chanrpc
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+
+	"github.com/YiuTerran/leaf/log"
+)
+
+// execute a call, ret is nil, interface{} or []interface{}
+type Handler func(id interface{}, args []interface{}) (ret interface{}, err error)
+
+// wrap a call, must call next to continue the chain
+type Interceptor func(id interface{}, args []interface{}, next Handler) (ret interface{}, err error)
+
+// execute a callback of an async call
+type CbHandler func(id interface{}, ret interface{}, err error)
+
+// wrap a callback, must call next to continue the chain
+type CbInterceptor func(id interface{}, ret interface{}, err error, next CbHandler)
+
+var (
+	interceptors   []Interceptor
+	cbInterceptors []CbInterceptor
+)
+
+// add interceptors to all servers, they run before the ones of the server
+// you must call the function before any server starts
+func Use(is ...Interceptor) {
+	interceptors = append(interceptors, is...)
+}
+
+// add callback interceptors to all clients, they run before the ones of the client
+// you must call the function before any client starts
+func UseCb(is ...CbInterceptor) {
+	cbInterceptors = append(cbInterceptors, is...)
+}
+
+// you must call the function before calling Open and Go
+func (s *Server) Use(is ...Interceptor) {
+	s.interceptors = append(s.interceptors, is...)
+}
+
+// you must call the function before calling AsyncCall
+func (c *Client) UseCb(is ...CbInterceptor) {
+	c.cbInterceptors = append(c.cbInterceptors, is...)
+}
+
+func chain(h Handler, global []Interceptor, local []Interceptor) Handler {
+	for i := len(local) - 1; i >= 0; i-- {
+		h = wrap(h, local[i])
+	}
+	for i := len(global) - 1; i >= 0; i-- {
+		h = wrap(h, global[i])
+	}
+	return h
+}
+
+func wrap(next Handler, i Interceptor) Handler {
+	return func(id interface{}, args []interface{}) (interface{}, error) {
+		return i(id, args, next)
+	}
+}
+
+func chainCb(h CbHandler, global []CbInterceptor, local []CbInterceptor) CbHandler {
+	for i := len(local) - 1; i >= 0; i-- {
+		h = wrapCb(h, local[i])
+	}
+	for i := len(global) - 1; i >= 0; i-- {
+		h = wrapCb(h, global[i])
+	}
+	return h
+}
+
+func wrapCb(next CbHandler, i CbInterceptor) CbHandler {
+	return func(id interface{}, ret interface{}, err error) {
+		i(id, ret, err, next)
+	}
+}
+
+// log the calls that take longer than d
+func SlowCall(d time.Duration) Interceptor {
+	return func(id interface{}, args []interface{}, next Handler) (interface{}, error) {
+		start := time.Now()
+		ret, err := next(id, args)
+		if cost := time.Since(start); cost > d {
+			log.Warn("function id %v: slow call, cost %v", id, cost)
+		}
+		return ret, err
+	}
+}
+
+// convert a panic to the error of the call, report is called with the stack
+func Recover(report func(id interface{}, r interface{}, stack []byte)) Interceptor {
+	return func(id interface{}, args []interface{}, next Handler) (ret interface{}, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				buf := make([]byte, log.LenStackBuf)
+				l := runtime.Stack(buf, false)
+				if report != nil {
+					report(id, r, buf[:l])
+				}
+				ret = nil
+				err = fmt.Errorf("%v", r)
+			}
+		}()
+
+		return next(id, args)
+	}
+}
@@ -0,0 +1,72 @@
+package chanrpc_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/YiuTerran/leaf/chanrpc"
+)
+
+func TestInterceptor(t *testing.T) {
+	s := chanrpc.NewServer(10)
+	s.Register("f1", func(args []interface{}) interface{} {
+		return args[0]
+	})
+	s.Register("panic", func(args []interface{}) {
+		panic("oops")
+	})
+
+	var trace []string
+	var reported interface{}
+	s.Use(func(id interface{}, args []interface{}, next chanrpc.Handler) (interface{}, error) {
+		trace = append(trace, "outer")
+		return next(id, args)
+	}, func(id interface{}, args []interface{}, next chanrpc.Handler) (interface{}, error) {
+		trace = append(trace, "auth")
+		if args[0] == "guest" {
+			return nil, errors.New("permission denied")
+		}
+		return next(id, args)
+	})
+	s.Use(chanrpc.Recover(func(id interface{}, r interface{}, stack []byte) {
+		reported = id
+	}))
+
+	c := chanrpc.NewClient(10)
+	c.Attach(s)
+	var cbIDs []interface{}
+	c.UseCb(func(id interface{}, ret interface{}, err error, next chanrpc.CbHandler) {
+		cbIDs = append(cbIDs, id)
+		next(id, ret, err)
+	})
+
+	c.AsyncCall("f1", "admin", func(ret interface{}, err error) {
+		if err != nil || ret != "admin" {
+			t.Errorf("unexpected result %v, %v", ret, err)
+		}
+	})
+	c.AsyncCall("f1", "guest", func(ret interface{}, err error) {
+		if err == nil || err.Error() != "permission denied" {
+			t.Errorf("expected auth error, got %v", err)
+		}
+	})
+	c.AsyncCall("panic", "admin", func(err error) {
+		if err == nil || err.Error() != "oops" {
+			t.Errorf("expected panic error, got %v", err)
+		}
+	})
+	for i := 0; i < 3; i++ {
+		s.Exec(<-s.ChanCall)
+		c.Cb(<-c.ChanAsyncRet)
+	}
+
+	if len(trace) != 6 || trace[0] != "outer" || trace[1] != "auth" {
+		t.Fatalf("unexpected trace %v", trace)
+	}
+	if reported != "panic" {
+		t.Fatalf("panic not reported, got %v", reported)
+	}
+	if len(cbIDs) != 3 || cbIDs[2] != "panic" {
+		t.Fatalf("unexpected callback ids %v", cbIDs)
+	}
+}
@@ -0,0 +1,120 @@
+package chanrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// what to do when the channel of the server is full
+type OverflowPolicy int
+
+const (
+	// Go and Call block, AsyncCall fails with ErrChannelFull
+	OverflowDefault OverflowPolicy = iota
+	// block until there is room or the context of the call is done
+	OverflowBlock
+	// block at most the timeout of the policy, then fail with ErrChannelFull
+	OverflowBlockTimeout
+	// drop the new call, it fails with ErrDropped
+	OverflowDropNewest
+	// drop the oldest pending call to make room, it fails with ErrDropped
+	OverflowDropOldest
+	// fail with ErrChannelFull immediately
+	OverflowError
+)
+
+var (
+	ErrChannelFull = errors.New("chanrpc channel full")
+	ErrDropped     = errors.New("chanrpc call dropped")
+)
+
+// it applies to Go, TryGo, Call and AsyncCall
+// you must call the function before calling Open and Go
+func (s *Server) SetOverflowPolicy(policy OverflowPolicy, timeout time.Duration) {
+	if policy == OverflowBlockTimeout && timeout <= 0 {
+		panic("invalid overflow timeout")
+	}
+
+	s.overflow = policy
+	s.overflowTimeout = timeout
+}
+
+// same as Go but return the error if the call is not enqueued
+// goroutine safe
+func (s *Server) TryGo(id interface{}, args ...interface{}) error {
+	return s.goPriority(-1, id, args)
+}
+
+// block: the legacy behaviour of OverflowDefault
+func (s *Server) enqueue(ctx context.Context, ci *CallInfo, block bool) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = r.(error)
+		}
+		if err == ErrChannelFull || err == ErrDropped {
+			s.stats.drop()
+		}
+	}()
+
+	lane := s.Lane(ci.priority)
+	policy := s.overflow
+	if policy == OverflowDefault {
+		if block {
+			policy = OverflowBlock
+		} else {
+			policy = OverflowError
+		}
+	}
+	// nothing to drop from an unbuffered channel
+	if policy == OverflowDropOldest && cap(lane) == 0 {
+		policy = OverflowDropNewest
+	}
+
+	switch policy {
+	case OverflowBlock:
+		select {
+		case lane <- ci:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	case OverflowBlockTimeout:
+		t := time.NewTimer(s.overflowTimeout)
+		defer t.Stop()
+		select {
+		case lane <- ci:
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-t.C:
+			err = ErrChannelFull
+		}
+	case OverflowDropNewest, OverflowError:
+		select {
+		case lane <- ci:
+		default:
+			if policy == OverflowError {
+				err = ErrChannelFull
+			} else {
+				err = ErrDropped
+			}
+		}
+	case OverflowDropOldest:
+		for {
+			select {
+			case lane <- ci:
+				return
+			default:
+			}
+			select {
+			case old := <-lane:
+				s.stats.drop()
+				_ = s.ret(old, &RetInfo{err: ErrDropped})
+			default:
+			}
+		}
+	default:
+		panic(fmt.Sprintf("invalid overflow policy %v", policy))
+	}
+	return
+}
@@ -0,0 +1,47 @@
+package chanrpc_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/YiuTerran/leaf/chanrpc"
+)
+
+func TestOverflowPolicy(t *testing.T) {
+	s := chanrpc.NewServer(1)
+	s.Register("f1", func(args []interface{}) interface{} {
+		return args[0]
+	})
+
+	s.SetOverflowPolicy(chanrpc.OverflowError, 0)
+	if err := s.TryGo("f1", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.TryGo("f1", 2); err != chanrpc.ErrChannelFull {
+		t.Fatalf("expected channel full, got %v", err)
+	}
+	if err := s.TryGo("unknown"); err == nil {
+		t.Fatal("expected not registered error")
+	}
+
+	s.SetOverflowPolicy(chanrpc.OverflowBlockTimeout, 10*time.Millisecond)
+	if _, err := s.Call1("f1", 3); err != chanrpc.ErrChannelFull {
+		t.Fatalf("expected channel full, got %v", err)
+	}
+
+	s.SetOverflowPolicy(chanrpc.OverflowDropOldest, 0)
+	c := chanrpc.NewClient(1)
+	c.Attach(s)
+	s.Exec(<-s.ChanCall)
+	c.AsyncCall("f1", 4, func(ret interface{}, err error) {
+		if err != chanrpc.ErrDropped {
+			t.Errorf("expected dropped, got %v, %v", ret, err)
+		}
+	})
+	s.Go("f1", 5)
+	c.Cb(<-c.ChanAsyncRet)
+
+	if stat := s.Stat(); stat.Dropped != 4 {
+		t.Fatalf("unexpected dropped count %v", stat.Dropped)
+	}
+}
@@ -0,0 +1,119 @@
+package chanrpc
+
+import (
+	"context"
+	"errors"
+)
+
+type Priority int
+
+var ErrInvalidPriority = errors.New("chanrpc invalid priority")
+
+// calls of higher priority are executed first, see ExecPriority
+const (
+	PriorityNormal Priority = iota // ChanCall
+	PriorityHigh
+	PriorityUrgent
+	numPriority
+)
+
+// length of the lanes above PriorityNormal of a new server, at most the length of ChanCall.
+// they only hold the few urgent calls, see SetPriorityLen
+var PriorityLen = 16
+
+type priorityKey struct{}
+
+// calls made with the returned context use priority p
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// default priority of the function
+// goroutine safe
+func (s *Server) SetPriority(id interface{}, p Priority) {
+	if p < PriorityNormal || p >= numPriority {
+		panic("invalid priority")
+	}
+	s.mutexFuncs.Lock()
+	defer s.mutexFuncs.Unlock()
+	if _, ok := s.functions[id]; !ok {
+		panic("function id not registered")
+	}
+
+	s.priority[id] = p
+}
+
+// register the function with the default priority p, see SetPriority
+// goroutine safe
+func (s *Server) RegisterPriority(id interface{}, f interface{}, p Priority) {
+	if p < PriorityNormal || p >= numPriority {
+		panic("invalid priority")
+	}
+	s.Register(id, f)
+	s.mutexFuncs.Lock()
+	defer s.mutexFuncs.Unlock()
+	s.priority[id] = p
+}
+
+// change the length of the lanes above PriorityNormal
+// you must call the function before calling Open and Go
+func (s *Server) SetPriorityLen(l int) {
+	s.mutexLanes.Lock()
+	defer s.mutexLanes.Unlock()
+	for p := PriorityNormal + 1; p < numPriority; p++ {
+		s.lanes[p] = make(chan *CallInfo, l)
+	}
+}
+
+func (s *Server) priorityOf(ctx context.Context, id interface{}) Priority {
+	if ctx != nil {
+		if p, ok := ctx.Value(priorityKey{}).(Priority); ok && p >= PriorityNormal && p < numPriority {
+			return p
+		}
+	}
+	_, p := s.function(id)
+	return p
+}
+
+// the channel of calls with priority p, ChanCall for PriorityNormal
+func (s *Server) Lane(p Priority) chan *CallInfo {
+	s.mutexLanes.RLock()
+	defer s.mutexLanes.RUnlock()
+	return s.lanes[p]
+}
+
+// execute at most n pending calls of the lanes above PriorityNormal,
+// the highest first. the loop of the server should call it before waiting
+// on all lanes, n limits the burst so that the others are not starved.
+// return the number of executed calls
+func (s *Server) ExecPriority(n int) int {
+	executed := 0
+	for executed < n {
+		var ci *CallInfo
+		select {
+		case ci = <-s.Lane(PriorityUrgent):
+		default:
+			select {
+			case ci = <-s.Lane(PriorityHigh):
+			default:
+			}
+		}
+		if ci == nil {
+			break
+		}
+		s.Exec(ci)
+		executed++
+	}
+	return executed
+}
+
+// same as TryGo but the call is queued with priority p,
+// fail with ErrInvalidPriority if p is not a valid priority
+// goroutine safe
+func (s *Server) GoPriority(p Priority, id interface{}, args ...interface{}) error {
+	if p < PriorityNormal || p >= numPriority {
+		s.stats.drop()
+		return ErrInvalidPriority
+	}
+	return s.goPriority(p, id, args)
+}
@@ -0,0 +1,80 @@
+package chanrpc_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/YiuTerran/leaf/chanrpc"
+)
+
+func TestPriority(t *testing.T) {
+	s := chanrpc.NewServer(10)
+	var order []string
+	s.Register("play", func(args []interface{}) {
+		order = append(order, "play")
+	})
+	s.Register("kick", func(args []interface{}) {
+		order = append(order, "kick")
+	})
+	s.Register("shutdown", func(args []interface{}) interface{} {
+		order = append(order, "shutdown")
+		return nil
+	})
+	s.SetPriority("kick", chanrpc.PriorityHigh)
+
+	s.Go("play")
+	s.Go("kick")
+	if err := s.GoPriority(chanrpc.PriorityUrgent, "play"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.GoPriority(chanrpc.PriorityUrgent+1, "play"); err != chanrpc.ErrInvalidPriority {
+		t.Fatalf("expected ErrInvalidPriority, got %v", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		ctx := chanrpc.WithPriority(context.Background(), chanrpc.PriorityUrgent)
+		_, _ = s.Call1Context(ctx, "shutdown")
+		close(done)
+	}()
+	for len(s.Lane(chanrpc.PriorityUrgent)) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if n := s.ExecPriority(2); n != 2 {
+		t.Fatalf("expected 2 calls, got %v", n)
+	}
+	<-done
+	if n := s.ExecPriority(10); n != 1 {
+		t.Fatalf("expected 1 call, got %v", n)
+	}
+	s.Exec(<-s.ChanCall)
+
+	expected := []string{"play", "shutdown", "kick", "play"}
+	for i := range expected {
+		if order[i] != expected[i] {
+			t.Fatalf("unexpected order %v", order)
+		}
+	}
+}
+
+func TestRegisterPriority(t *testing.T) {
+	s := chanrpc.NewServer(100)
+	if c := cap(s.Lane(chanrpc.PriorityUrgent)); c != chanrpc.PriorityLen {
+		t.Fatalf("unexpected lane length %v", c)
+	}
+	s.SetPriorityLen(1)
+	s.SetOverflowPolicy(chanrpc.OverflowError, 0)
+	s.RegisterPriority("kick", func(args []interface{}) {}, chanrpc.PriorityUrgent)
+
+	s.Go("kick")
+	if len(s.Lane(chanrpc.PriorityUrgent)) != 1 {
+		t.Fatal("call not in the urgent lane")
+	}
+	if err := s.TryGo("kick"); err != chanrpc.ErrChannelFull {
+		t.Fatalf("expected ErrChannelFull, got %v", err)
+	}
+	if stat := s.Stat(); stat.QueueLen != 1 || stat.QueueCap != 100 {
+		t.Fatalf("unexpected stat %v", stat)
+	}
+}
@@ -0,0 +1,143 @@
+package record
+
+import (
+	"bufio"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/YiuTerran/leaf/chanrpc"
+	"github.com/YiuTerran/leaf/log"
+)
+
+//录制chanrpc.Server执行的调用，用于按相同顺序重放，复现模块状态
+//参数用gob序列化，自定义类型需要用gob.Register注册；
+//无法序列化的参数（比如gate.Agent）可以用Sanitize替换
+
+type Record struct {
+	Time   time.Time
+	ID     string //fmt.Sprint(id)，重放时与server上注册的id匹配
+	Source string //go, call, async, future
+	Args   []interface{}
+	Err    string //参数序列化失败的原因，这样的记录不能重放
+}
+
+type Recorder struct {
+	//返回用于录制的参数，可以去掉或替换无法序列化的参数
+	Sanitize func(id interface{}, args []interface{}) []interface{}
+
+	mutex sync.Mutex
+	file  *os.File
+	w     *bufio.Writer
+	enc   *gob.Encoder
+}
+
+func NewRecorder(path string) (*Recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	r := new(Recorder)
+	r.file = f
+	r.w = bufio.NewWriter(f)
+	r.enc = gob.NewEncoder(r.w)
+	return r, nil
+}
+
+//录制server执行的所有调用
+// you must call the function before calling Open and Go of the server
+func (r *Recorder) Attach(server *chanrpc.Server) {
+	server.SetRecorder(r.record)
+}
+
+func (r *Recorder) record(ci *chanrpc.CallInfo) {
+	rec := &Record{
+		Time:   time.Now(),
+		ID:     fmt.Sprint(ci.ID()),
+		Source: ci.Source(),
+		Args:   ci.Args(),
+	}
+	if r.Sanitize != nil {
+		rec.Args = r.Sanitize(ci.ID(), rec.Args)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.enc == nil {
+		return
+	}
+	// gob may write part of the record before failing, check the args first
+	if err := gob.NewEncoder(ioutil.Discard).Encode(rec); err != nil {
+		rec.Args = nil
+		rec.Err = err.Error()
+	}
+	if err := r.enc.Encode(rec); err != nil {
+		log.Error("record function id %v error: %v", rec.ID, err)
+	}
+}
+
+func (r *Recorder) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.enc == nil {
+		return nil
+	}
+	r.enc = nil
+	err := r.w.Flush()
+	if e := r.file.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+//读取所有记录
+func Load(path string) ([]*Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var recs []*Record
+	dec := gob.NewDecoder(bufio.NewReader(f))
+	for {
+		rec := new(Record)
+		err := dec.Decode(rec)
+		if err == io.EOF {
+			return recs, nil
+		}
+		if err != nil {
+			return recs, err
+		}
+		recs = append(recs, rec)
+	}
+}
+
+//按录制的顺序在当前goroutine中执行所有调用，server应当属于一个新初始化、没有运行的模块
+//返回第一个无法重放的记录的错误，handler返回的错误不影响重放
+func Replay(path string, server *chanrpc.Server) error {
+	recs, err := Load(path)
+	if err != nil {
+		return err
+	}
+
+	ids := make(map[string]interface{})
+	for _, id := range server.IDs() {
+		ids[fmt.Sprint(id)] = id
+	}
+	for i, rec := range recs {
+		if rec.Err != "" {
+			return fmt.Errorf("record %v of function id %v: %v", i, rec.ID, rec.Err)
+		}
+		id, ok := ids[rec.ID]
+		if !ok {
+			return fmt.Errorf("record %v: function id %v not registered", i, rec.ID)
+		}
+		_, _ = server.Invoke(id, rec.Args...)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package record_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/YiuTerran/leaf/chanrpc"
+	"github.com/YiuTerran/leaf/chanrpc/record"
+)
+
+type counter struct {
+	server *chanrpc.Server
+	total  int
+}
+
+func newCounter() *counter {
+	c := &counter{server: chanrpc.NewServer(10)}
+	c.server.RegisterTyped("add", func(n int) {
+		c.total += n
+	})
+	c.server.RegisterTyped("double", func() int {
+		c.total *= 2
+		return c.total
+	})
+	return c
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "record")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "calls.rec")
+	r, err := record.NewRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := newCounter()
+	r.Attach(c.server)
+	c.server.Go("add", 1)
+	c.server.Go("double")
+	c.server.Go("add", 3)
+	for i := 0; i < 3; i++ {
+		c.server.Exec(<-c.server.ChanCall)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	recs, err := record.Load(path)
+	if err != nil || len(recs) != 3 || recs[1].ID != "double" || recs[2].Source != "go" {
+		t.Fatalf("unexpected records %v, %v", recs, err)
+	}
+
+	replayed := newCounter()
+	if err := record.Replay(path, replayed.server); err != nil {
+		t.Fatal(err)
+	}
+	if replayed.total != c.total || c.total != 5 {
+		t.Fatalf("expected %v, got %v", c.total, replayed.total)
+	}
+}
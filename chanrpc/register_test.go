@@ -0,0 +1,48 @@
+package chanrpc_test
+
+import (
+	"testing"
+
+	"github.com/YiuTerran/leaf/chanrpc"
+)
+
+func TestReplaceAndUnregister(t *testing.T) {
+	s := chanrpc.NewServer(10)
+	s.Register("f1", func(args []interface{}) interface{} {
+		return 1
+	})
+	s.Replace("f1", func(args []interface{}) interface{} {
+		return 2
+	})
+	s.Go("f1")
+	s.Exec(<-s.ChanCall)
+
+	c := chanrpc.NewClient(10)
+	c.Attach(s)
+	c.AsyncCall("f1", func(ret interface{}, err error) {
+		if ret != 2 {
+			t.Errorf("expected the new function, got %v, %v", ret, err)
+		}
+	})
+	s.Exec(<-s.ChanCall)
+	c.Cb(<-c.ChanAsyncRet)
+
+	if !s.Unregister("f1") || s.Unregister("f1") {
+		t.Fatal("unexpected unregister result")
+	}
+	if err := s.TryGo("f1"); err == nil {
+		t.Fatal("expected not registered error")
+	}
+
+	// register again after unregister
+	s.RegisterTyped("f1", func() int {
+		return 3
+	})
+	c.AsyncCall("f1", func(ret interface{}, err error) {
+		if ret != 3 {
+			t.Errorf("expected the new function, got %v, %v", ret, err)
+		}
+	})
+	s.Exec(<-s.ChanCall)
+	c.Cb(<-c.ChanAsyncRet)
+}
@@ -0,0 +1,315 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/YiuTerran/leaf/log"
+	"github.com/YiuTerran/leaf/network"
+	"github.com/YiuTerran/leaf/network/tcp"
+)
+
+//远程chanrpc.Server的代理，语义与chanrpc.Client相同
+//同步调用goroutine safe；AsyncCall、Cb和Close只能在同一个goroutine中调用
+type Client struct {
+	Addr            string
+	ConnectInterval time.Duration
+	PendingWriteNum int
+	MaxMsgLen       uint32
+	AutoReconnect   bool
+	Codec           Codec
+	ChanAsyncRet    chan *RetInfo
+
+	mutex            sync.Mutex
+	conn             *tcp.Conn
+	seq              uint64
+	pending          map[uint64]*pendingCall
+	pendingAsyncCall int
+	tcpClient        *tcp.Client
+}
+
+type RetInfo struct {
+	ret interface{}
+	err error
+	// callback:
+	// func(err error)
+	// func(ret interface{}, err error)
+	// func(ret []interface{}, err error)
+	cb interface{}
+}
+
+type pendingCall struct {
+	kind    int
+	chanRet chan *RetInfo
+	cb      interface{}
+}
+
+func NewClient(addr string, l int) *Client {
+	c := new(Client)
+	c.Addr = addr
+	c.AutoReconnect = true
+	c.ChanAsyncRet = make(chan *RetInfo, l)
+	return c
+}
+
+func (c *Client) Start() {
+	if c.Codec == nil {
+		c.Codec = GobCodec{}
+	}
+	if c.MaxMsgLen == 0 {
+		c.MaxMsgLen = defaultMaxMsgLen
+	}
+	c.pending = make(map[uint64]*pendingCall)
+	parser := tcp.NewDefaultParser()
+	parser.SetMsgLen(4, 1, c.MaxMsgLen)
+
+	c.tcpClient = &tcp.Client{
+		Addr:            c.Addr,
+		ConnNum:         1,
+		ConnectInterval: c.ConnectInterval,
+		PendingWriteNum: c.PendingWriteNum,
+		AutoReconnect:   c.AutoReconnect,
+		Parser:          parser,
+		NewAgent: func(conn *tcp.Conn) network.Agent {
+			c.mutex.Lock()
+			c.conn = conn
+			c.mutex.Unlock()
+			return &clientAgent{conn: conn, client: c}
+		},
+	}
+	c.tcpClient.Start()
+}
+
+//关闭连接，未完成的调用都会返回错误，并执行完所有异步回调
+func (c *Client) Close() {
+	if c.tcpClient != nil {
+		c.tcpClient.Close()
+		c.tcpClient = nil
+	}
+	c.failPending(errors.New("remote client closed"))
+	for c.pendingAsyncCall > 0 {
+		c.Cb(<-c.ChanAsyncRet)
+	}
+}
+
+func (c *Client) Idle() bool {
+	return c.pendingAsyncCall == 0
+}
+
+func (c *Client) failPending(err error) {
+	c.mutex.Lock()
+	pending := c.pending
+	c.pending = make(map[uint64]*pendingCall)
+	c.conn = nil
+	c.mutex.Unlock()
+
+	for _, p := range pending {
+		p.chanRet <- &RetInfo{err: err, cb: p.cb}
+	}
+}
+
+func (c *Client) send(id string, kind int, args []interface{}, p *pendingCall) (seq uint64, err error) {
+	c.mutex.Lock()
+	conn := c.conn
+	if conn == nil {
+		c.mutex.Unlock()
+		return 0, fmt.Errorf("remote %v: not connected", c.Addr)
+	}
+	c.seq++
+	seq = c.seq
+	if p != nil {
+		c.pending[seq] = p
+	}
+	c.mutex.Unlock()
+
+	data, err := c.Codec.Marshal(&request{Seq: seq, ID: id, Kind: kind, Args: args})
+	if err == nil {
+		err = conn.WriteMsg(data)
+	}
+	if err != nil {
+		c.removePending(seq)
+		return 0, fmt.Errorf("function id %v: %v", id, err)
+	}
+	return seq, nil
+}
+
+func (c *Client) removePending(seq uint64) *pendingCall {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	p := c.pending[seq]
+	delete(c.pending, seq)
+	return p
+}
+
+func (c *Client) onResponse(resp *response) {
+	p := c.removePending(resp.Seq)
+	if p == nil {
+		// caller has given up
+		return
+	}
+
+	ri := &RetInfo{cb: p.cb}
+	if resp.Err != "" {
+		ri.err = errors.New(resp.Err)
+	}
+	switch p.kind {
+	case kind1:
+		if len(resp.Ret) > 0 {
+			ri.ret = resp.Ret[0]
+		}
+	case kindN:
+		ri.ret = resp.Ret
+	}
+	p.chanRet <- ri
+}
+
+//发送后不等待结果
+func (c *Client) Go(id string, args ...interface{}) {
+	if _, err := c.send(id, kindGo, args, nil); err != nil {
+		log.Error("remote go error: %v", err)
+	}
+}
+
+func (c *Client) call(ctx context.Context, id string, kind int, args []interface{}) (*RetInfo, error) {
+	p := &pendingCall{kind: kind, chanRet: make(chan *RetInfo, 1)}
+	seq, err := c.send(id, kind, args, p)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case ri := <-p.chanRet:
+		return ri, nil
+	case <-ctx.Done():
+		c.removePending(seq)
+		return nil, ctx.Err()
+	}
+}
+
+func (c *Client) Call0(id string, args ...interface{}) error {
+	return c.Call0Context(context.Background(), id, args...)
+}
+
+func (c *Client) Call1(id string, args ...interface{}) (interface{}, error) {
+	return c.Call1Context(context.Background(), id, args...)
+}
+
+func (c *Client) CallN(id string, args ...interface{}) ([]interface{}, error) {
+	return c.CallNContext(context.Background(), id, args...)
+}
+
+func (c *Client) Call0Context(ctx context.Context, id string, args ...interface{}) error {
+	ri, err := c.call(ctx, id, kind0, args)
+	if err != nil {
+		return err
+	}
+	return ri.err
+}
+
+func (c *Client) Call1Context(ctx context.Context, id string, args ...interface{}) (interface{}, error) {
+	ri, err := c.call(ctx, id, kind1, args)
+	if err != nil {
+		return nil, err
+	}
+	return ri.ret, ri.err
+}
+
+func (c *Client) CallNContext(ctx context.Context, id string, args ...interface{}) ([]interface{}, error) {
+	ri, err := c.call(ctx, id, kindN, args)
+	if err != nil {
+		return nil, err
+	}
+	ret, _ := ri.ret.([]interface{})
+	return ret, ri.err
+}
+
+func (c *Client) AsyncCall(id string, _args ...interface{}) {
+	if len(_args) < 1 {
+		panic("callback function not found")
+	}
+
+	args := _args[:len(_args)-1]
+	cb := _args[len(_args)-1]
+
+	var kind int
+	switch cb.(type) {
+	case func(error):
+		kind = kind0
+	case func(interface{}, error):
+		kind = kind1
+	case func([]interface{}, error):
+		kind = kindN
+	default:
+		panic("definition of callback function is invalid")
+	}
+
+	// too many calls
+	if c.pendingAsyncCall >= cap(c.ChanAsyncRet) {
+		execCb(&RetInfo{err: errors.New("too many calls"), cb: cb})
+		return
+	}
+
+	c.pendingAsyncCall++
+	_, err := c.send(id, kind, args, &pendingCall{kind: kind, chanRet: c.ChanAsyncRet, cb: cb})
+	if err != nil {
+		c.ChanAsyncRet <- &RetInfo{err: err, cb: cb}
+	}
+}
+
+func execCb(ri *RetInfo) {
+	defer func() {
+		if r := recover(); r != nil {
+			buf := make([]byte, log.LenStackBuf)
+			l := runtime.Stack(buf, false)
+			log.Error("%v: %s", r, buf[:l])
+		}
+	}()
+
+	// execute
+	switch ri.cb.(type) {
+	case func(error):
+		ri.cb.(func(error))(ri.err)
+	case func(interface{}, error):
+		ri.cb.(func(interface{}, error))(ri.ret, ri.err)
+	case func([]interface{}, error):
+		ret, _ := ri.ret.([]interface{})
+		ri.cb.(func([]interface{}, error))(ret, ri.err)
+	default:
+		panic("bug")
+	}
+}
+
+func (c *Client) Cb(ri *RetInfo) {
+	c.pendingAsyncCall--
+	execCb(ri)
+}
+
+type clientAgent struct {
+	conn   *tcp.Conn
+	client *Client
+}
+
+func (a *clientAgent) Run() {
+	for {
+		data, err := a.conn.ReadMsg()
+		if err != nil {
+			log.Debug("read remote response error: %v", err)
+			break
+		}
+
+		resp := new(response)
+		if err := a.client.Codec.Unmarshal(data, resp); err != nil {
+			log.Error("unmarshal remote response error: %v", err)
+			break
+		}
+		a.client.onResponse(resp)
+	}
+}
+
+func (a *clientAgent) OnClose() {
+	a.client.failPending(errors.New("remote connection closed"))
+}
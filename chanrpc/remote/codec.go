@@ -0,0 +1,49 @@
+package remote
+
+import (
+	"bytes"
+	"encoding/gob"
+)
+
+//请求和响应的序列化方式，开放给外部自定义
+type Codec interface {
+	// must goroutine safe
+	Marshal(v interface{}) ([]byte, error)
+	// must goroutine safe
+	Unmarshal(data []byte, v interface{}) error
+}
+
+//默认使用gob，参数和返回值中的自定义类型需要在两端都用gob.Register注册
+type GobCodec struct{}
+
+func (GobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+const (
+	kindGo = iota - 1 // no reply
+	kind0             // Call0
+	kind1             // Call1
+	kindN             // CallN
+)
+
+type request struct {
+	Seq  uint64
+	ID   string
+	Kind int
+	Args []interface{}
+}
+
+type response struct {
+	Seq uint64
+	Ret []interface{}
+	Err string
+}
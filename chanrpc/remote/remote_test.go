@@ -0,0 +1,122 @@
+package remote_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/YiuTerran/leaf/chanrpc"
+	"github.com/YiuTerran/leaf/chanrpc/remote"
+	"github.com/YiuTerran/leaf/log"
+)
+
+func TestRemoteCall(t *testing.T) {
+	log.InitLogger("")
+
+	rpc := chanrpc.NewServer(10)
+	rpc.Register("add", func(args []interface{}) interface{} {
+		return args[0].(int) + args[1].(int)
+	})
+	rpc.Register("nil", func(args []interface{}) interface{} {
+		return nil
+	})
+	rpc.RegisterTyped("fail", func() error {
+		return errors.New("failed")
+	})
+	rpc.Register("hidden", func(args []interface{}) {})
+	go func() {
+		for ci := range rpc.ChanCall {
+			rpc.Exec(ci)
+		}
+	}()
+	defer close(rpc.ChanCall)
+
+	s := &remote.Server{Addr: "127.0.0.1:36731", RPCServer: rpc}
+	s.Expose("add", "nil", "fail")
+	s.Start()
+	defer s.Close()
+
+	c := remote.NewClient(s.Addr, 10)
+	c.ConnectInterval = 10 * time.Millisecond
+	c.Start()
+	defer c.Close()
+
+	var (
+		ret interface{}
+		err error
+	)
+	for i := 0; i < 100; i++ {
+		if ret, err = c.Call1("add", 1, 2); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil || ret != 3 {
+		t.Fatalf("unexpected result %v, %v", ret, err)
+	}
+	if ret, err = c.Call1("nil"); err != nil || ret != nil {
+		t.Fatalf("unexpected result %v, %v", ret, err)
+	}
+	if err = c.Call0("fail"); err == nil || err.Error() != "failed" {
+		t.Fatalf("expected remote error, got %v", err)
+	}
+	if err = c.Call0("hidden"); err == nil {
+		t.Fatal("expected not exposed error")
+	}
+
+	c.AsyncCall("add", 2, 3, func(ret interface{}, err error) {
+		if err != nil || ret != 5 {
+			t.Errorf("unexpected result %v, %v", ret, err)
+		}
+	})
+	c.Cb(<-c.ChanAsyncRet)
+}
+
+func TestRemoteMaxPendingCall(t *testing.T) {
+	log.InitLogger("")
+
+	rpc := chanrpc.NewServer(10)
+	entered := make(chan struct{}, 10)
+	release := make(chan struct{})
+	rpc.Register("wait", func(args []interface{}) {
+		entered <- struct{}{}
+		<-release
+	})
+	go func() {
+		for ci := range rpc.ChanCall {
+			rpc.Exec(ci)
+		}
+	}()
+	defer close(rpc.ChanCall)
+
+	s := &remote.Server{Addr: "127.0.0.1:36732", RPCServer: rpc, MaxPendingCall: 2}
+	s.Expose("wait")
+	s.Start()
+	defer s.Close()
+
+	c := remote.NewClient(s.Addr, 10)
+	c.ConnectInterval = 10 * time.Millisecond
+	c.Start()
+	defer c.Close()
+
+	for i := 0; i < 100; i++ {
+		if err := c.Call0("missing"); strings.Contains(err.Error(), "not exposed") {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for i := 0; i < 4; i++ {
+		c.AsyncCall("wait", func(err error) {})
+	}
+	<-entered
+	time.Sleep(50 * time.Millisecond)
+	//第一个调用在执行，第二个在排队，剩下的还没有读取
+	if n := len(rpc.ChanCall); n != 1 {
+		t.Fatalf("expected 1 queued call, got %v", n)
+	}
+	close(release)
+	for i := 0; i < 4; i++ {
+		c.Cb(<-c.ChanAsyncRet)
+	}
+}
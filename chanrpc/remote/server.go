@@ -0,0 +1,187 @@
+package remote
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/YiuTerran/leaf/chanrpc"
+	"github.com/YiuTerran/leaf/log"
+	"github.com/YiuTerran/leaf/network"
+	"github.com/YiuTerran/leaf/network/tcp"
+)
+
+const (
+	defaultMaxMsgLen      = 4 * 1024 * 1024
+	defaultMaxPendingCall = 64
+)
+
+// 把一个chanrpc.Server的部分函数暴露给其他进程
+// 只支持string类型的function id
+type Server struct {
+	Addr            string
+	MaxConnNum      int
+	PendingWriteNum int
+	MaxMsgLen       uint32
+	MaxPendingCall  int //每个连接同时处理的调用数量，超过时暂停读取该连接的请求
+	Codec           Codec
+	RPCServer       *chanrpc.Server
+
+	exposed   map[string]struct{}
+	mutex     sync.RWMutex
+	tcpServer *tcp.Server
+}
+
+// 允许远程调用的function id，未暴露的调用会返回错误
+// goroutine safe
+func (s *Server) Expose(ids ...string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.exposed == nil {
+		s.exposed = make(map[string]struct{})
+	}
+	for _, id := range ids {
+		s.exposed[id] = struct{}{}
+	}
+}
+
+func (s *Server) isExposed(id string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	_, ok := s.exposed[id]
+	return ok
+}
+
+func (s *Server) Start() {
+	if s.RPCServer == nil {
+		log.Fatal("RPCServer must not be nil")
+	}
+	if s.Codec == nil {
+		s.Codec = GobCodec{}
+	}
+	if s.MaxMsgLen == 0 {
+		s.MaxMsgLen = defaultMaxMsgLen
+	}
+	if s.MaxPendingCall <= 0 {
+		s.MaxPendingCall = defaultMaxPendingCall
+	}
+	parser := tcp.NewDefaultParser()
+	parser.SetMsgLen(4, 1, s.MaxMsgLen)
+
+	s.tcpServer = &tcp.Server{
+		Addr:            s.Addr,
+		MaxConnNum:      s.MaxConnNum,
+		PendingWriteNum: s.PendingWriteNum,
+		Parser:          parser,
+		NewAgent: func(conn *tcp.Conn) network.Agent {
+			a := &serverAgent{conn: conn, server: s}
+			a.pending = make(chan struct{}, s.MaxPendingCall)
+			a.ctx, a.cancel = context.WithCancel(context.Background())
+			return a
+		},
+	}
+	s.tcpServer.Start()
+}
+
+func (s *Server) Close() {
+	if s.tcpServer != nil {
+		s.tcpServer.Close()
+		s.tcpServer = nil
+	}
+}
+
+// 可以直接作为module的Run
+func (s *Server) Run(closeSig chan struct{}) {
+	s.Start()
+	<-closeSig
+	s.Close()
+}
+
+type serverAgent struct {
+	conn    *tcp.Conn
+	server  *Server
+	ctx     context.Context
+	cancel  context.CancelFunc
+	pending chan struct{}
+}
+
+func (a *serverAgent) Run() {
+	for {
+		data, err := a.conn.ReadMsg()
+		if err != nil {
+			log.Debug("read remote request error: %v", err)
+			break
+		}
+
+		req := new(request)
+		if err := a.server.Codec.Unmarshal(data, req); err != nil {
+			log.Error("unmarshal remote request error: %v", err)
+			break
+		}
+
+		if !a.server.isExposed(req.ID) {
+			a.reply(req, nil, fmt.Errorf("function id %v: not exposed", req.ID))
+			continue
+		}
+		if req.Kind == kindGo {
+			a.server.RPCServer.Go(req.ID, req.Args...)
+			continue
+		}
+		a.pending <- struct{}{}
+		go a.call(req)
+	}
+}
+
+func (a *serverAgent) call(req *request) {
+	defer func() { <-a.pending }()
+	rpc := a.server.RPCServer
+	var (
+		ret []interface{}
+		err error
+	)
+	switch req.Kind {
+	case kind0:
+		err = rpc.Call0Context(a.ctx, req.ID, req.Args...)
+	case kind1:
+		var r interface{}
+		r, err = rpc.Call1Context(a.ctx, req.ID, req.Args...)
+		ret = []interface{}{r}
+	case kindN:
+		ret, err = rpc.CallNContext(a.ctx, req.ID, req.Args...)
+	default:
+		err = fmt.Errorf("function id %v: invalid call kind %v", req.ID, req.Kind)
+	}
+	a.reply(req, ret, err)
+}
+
+func (a *serverAgent) reply(req *request, ret []interface{}, err error) {
+	if req.Kind == kindGo {
+		if err != nil {
+			log.Error("remote go error: %v", err)
+		}
+		return
+	}
+
+	resp := &response{Seq: req.Seq, Ret: ret}
+	if err != nil {
+		resp.Err = err.Error()
+	}
+	data, e := a.server.Codec.Marshal(resp)
+	if e != nil {
+		resp.Ret = nil
+		resp.Err = fmt.Sprintf("function id %v: marshal result error: %v", req.ID, e)
+		data, e = a.server.Codec.Marshal(resp)
+		if e != nil {
+			log.Error("marshal remote response error: %v", e)
+			return
+		}
+	}
+	if e = a.conn.WriteMsg(data); e != nil {
+		log.Error("write remote response error: %v", e)
+	}
+}
+
+// 连接断开时，放弃还在等待的调用
+func (a *serverAgent) OnClose() {
+	a.cancel()
+}
@@ -0,0 +1,190 @@
+package chanrpc
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// upper bounds of the latency histogram, the last bucket has no bound
+var LatencyBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+type FuncStat struct {
+	ID      interface{}
+	Calls   uint64
+	Errors  uint64
+	Total   time.Duration
+	Max     time.Duration
+	Buckets []uint64 // len(LatencyBuckets)+1
+}
+
+func (f *FuncStat) Avg() time.Duration {
+	if f.Calls == 0 {
+		return 0
+	}
+	return f.Total / time.Duration(f.Calls)
+}
+
+type Stat struct {
+	QueueLen int // of all lanes
+	QueueCap int // of ChanCall
+	Dropped  uint64
+	Funcs    []*FuncStat // sorted by total time, the hottest first
+}
+
+type stats struct {
+	sync.Mutex
+	funcs   map[interface{}]*FuncStat
+	dropped uint64
+}
+
+func newStats() *stats {
+	st := new(stats)
+	st.funcs = make(map[interface{}]*FuncStat)
+	return st
+}
+
+func (st *stats) record(id interface{}, d time.Duration, failed bool) {
+	st.Lock()
+	defer st.Unlock()
+
+	f := st.funcs[id]
+	if f == nil {
+		f = &FuncStat{ID: id, Buckets: make([]uint64, len(LatencyBuckets)+1)}
+		st.funcs[id] = f
+	}
+	f.Calls++
+	if failed {
+		f.Errors++
+	}
+	f.Total += d
+	if d > f.Max {
+		f.Max = d
+	}
+	i := sort.Search(len(LatencyBuckets), func(i int) bool {
+		return d <= LatencyBuckets[i]
+	})
+	f.Buckets[i]++
+}
+
+func (st *stats) drop() {
+	atomic.AddUint64(&st.dropped, 1)
+}
+
+// goroutine safe
+func (s *Server) Stat() *Stat {
+	stat := new(Stat)
+	s.mutexLanes.RLock()
+	for _, lane := range s.lanes {
+		stat.QueueLen += len(lane)
+	}
+	stat.QueueCap = cap(s.ChanCall)
+	s.mutexLanes.RUnlock()
+	stat.Dropped = atomic.LoadUint64(&s.stats.dropped)
+
+	s.stats.Lock()
+	for _, f := range s.stats.funcs {
+		c := *f
+		c.Buckets = append([]uint64(nil), f.Buckets...)
+		stat.Funcs = append(stat.Funcs, &c)
+	}
+	s.stats.Unlock()
+
+	sort.Slice(stat.Funcs, func(i, j int) bool {
+		return stat.Funcs[i].Total > stat.Funcs[j].Total
+	})
+	return stat
+}
+
+func (stat *Stat) String() string {
+	output := fmt.Sprintf("queue: %v/%v, dropped: %v", stat.QueueLen, stat.QueueCap, stat.Dropped)
+	for _, f := range stat.Funcs {
+		output += fmt.Sprintf("\r\n  %v - calls: %v, errors: %v, avg: %v, max: %v, histogram: %v",
+			f.ID, f.Calls, f.Errors, f.Avg(), f.Max, f.Buckets)
+	}
+	return output
+}
+
+// named servers, the console prints the statistics of its registry
+// goroutine safe
+type Registry struct {
+	servers map[string]*Server
+	mutex   sync.RWMutex
+}
+
+func NewRegistry() *Registry {
+	r := new(Registry)
+	r.servers = make(map[string]*Server)
+	return r
+}
+
+var registry = NewRegistry()
+
+// the registry of RegisterServer, used by the default console
+func DefaultRegistry() *Registry {
+	return registry
+}
+
+// make the server visible to the console by name
+func (r *Registry) Register(name string, s *Server) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.servers[name] = s
+	s.name = name
+}
+
+func (r *Registry) Unregister(name string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.servers, name)
+}
+
+// like Unregister, but only if name still refers to s,
+// so that an old module instance does not remove its replacement
+func (r *Registry) UnregisterIf(name string, s *Server) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.servers[name] == s {
+		delete(r.servers, name)
+	}
+}
+
+func (r *Registry) Servers() map[string]*Server {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	ss := make(map[string]*Server, len(r.servers))
+	for name, s := range r.servers {
+		ss[name] = s
+	}
+	return ss
+}
+
+// goroutine safe
+func RegisterServer(name string, s *Server) {
+	registry.Register(name, s)
+}
+
+// goroutine safe
+func UnregisterServer(name string) {
+	registry.Unregister(name)
+}
+
+// goroutine safe
+func UnregisterServerIf(name string, s *Server) {
+	registry.UnregisterIf(name, s)
+}
+
+// goroutine safe
+func Servers() map[string]*Server {
+	return registry.Servers()
+}
@@ -0,0 +1,31 @@
+package chanrpc_test
+
+import (
+	"testing"
+
+	"github.com/YiuTerran/leaf/chanrpc"
+)
+
+func TestStat(t *testing.T) {
+	s := chanrpc.NewServer(1)
+	s.Register("f0", func(args []interface{}) {})
+	s.Go("f0")
+	s.Go("unknown")
+
+	// channel full
+	c := chanrpc.NewClient(1)
+	c.Attach(s)
+	c.AsyncCall("f0", func(err error) {})
+	c.Cb(<-c.ChanAsyncRet)
+
+	stat := s.Stat()
+	if stat.QueueLen != 1 || stat.QueueCap != 1 || stat.Dropped != 2 {
+		t.Fatalf("unexpected stat %v", stat)
+	}
+
+	s.Exec(<-s.ChanCall)
+	stat = s.Stat()
+	if len(stat.Funcs) != 1 || stat.Funcs[0].ID != "f0" || stat.Funcs[0].Calls != 1 {
+		t.Fatalf("unexpected stat %v", stat)
+	}
+}
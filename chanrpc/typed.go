@@ -0,0 +1,134 @@
+package chanrpc
+
+import (
+	"fmt"
+	"reflect"
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// a function with arbitrary signature, called by reflection
+type typedFunc struct {
+	fn reflect.Value
+	// number of results except the trailing error
+	numRet   int
+	hasError bool
+}
+
+func newTypedFunc(id interface{}, f interface{}) *typedFunc {
+	fn := reflect.ValueOf(f)
+	if fn.Kind() != reflect.Func {
+		panic(fmt.Sprintf("function id %v: function required", id))
+	}
+
+	t := new(typedFunc)
+	t.fn = fn
+	ft := fn.Type()
+	t.numRet = ft.NumOut()
+	if t.numRet > 0 && ft.Out(t.numRet-1) == errorType {
+		t.hasError = true
+		t.numRet--
+	}
+	return t
+}
+
+// same as the n of Client.f
+func (t *typedFunc) kind() int {
+	switch t.numRet {
+	case 0:
+		return 0
+	case 1:
+		return 1
+	default:
+		return 2
+	}
+}
+
+func nillable(k reflect.Kind) bool {
+	switch k {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+		return true
+	}
+	return false
+}
+
+func (t *typedFunc) in(args []interface{}) ([]reflect.Value, error) {
+	ft := t.fn.Type()
+	numIn := ft.NumIn()
+	if ft.IsVariadic() {
+		if len(args) < numIn-1 {
+			return nil, fmt.Errorf("expected at least %v arguments, got %v", numIn-1, len(args))
+		}
+	} else if len(args) != numIn {
+		return nil, fmt.Errorf("expected %v arguments, got %v", numIn, len(args))
+	}
+
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		var pt reflect.Type
+		if ft.IsVariadic() && i >= numIn-1 {
+			pt = ft.In(numIn - 1).Elem()
+		} else {
+			pt = ft.In(i)
+		}
+
+		if arg == nil {
+			if !nillable(pt.Kind()) {
+				return nil, fmt.Errorf("argument %v: expected %v, got nil", i, pt)
+			}
+			in[i] = reflect.Zero(pt)
+			continue
+		}
+		v := reflect.ValueOf(arg)
+		if !v.Type().AssignableTo(pt) {
+			return nil, fmt.Errorf("argument %v: expected %v, got %v", i, pt, v.Type())
+		}
+		in[i] = v
+	}
+	return in, nil
+}
+
+func (t *typedFunc) call(args []interface{}) *RetInfo {
+	in, err := t.in(args)
+	if err != nil {
+		return &RetInfo{err: err}
+	}
+
+	out := t.fn.Call(in)
+	ri := new(RetInfo)
+	if t.hasError {
+		if e := out[len(out)-1]; !e.IsNil() {
+			ri.err = e.Interface().(error)
+		}
+		out = out[:len(out)-1]
+	}
+
+	switch t.kind() {
+	case 1:
+		ri.ret = out[0].Interface()
+	case 2:
+		ret := make([]interface{}, len(out))
+		for i, v := range out {
+			ret[i] = v.Interface()
+		}
+		ri.ret = ret
+	}
+	return ri
+}
+
+// register a function of any signature, e.g. func(*LoginReq, gate.Agent) (*LoginResp, error)
+//
+// arguments are checked at call time and a mismatch is returned to the caller,
+// a trailing error result becomes the error of the call.
+// call it with Call0 if it has no other result, Call1 if one, CallN if more.
+//
+// goroutine safe
+func (s *Server) RegisterTyped(id interface{}, f interface{}) {
+	s.set(id, newTypedFunc(id, f), false)
+}
+
+// register or replace the function, see Replace
+// goroutine safe
+func (s *Server) ReplaceTyped(id interface{}, f interface{}) {
+	s.set(id, newTypedFunc(id, f), true)
+}
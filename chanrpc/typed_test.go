@@ -0,0 +1,57 @@
+package chanrpc_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/YiuTerran/leaf/chanrpc"
+)
+
+type loginReq struct {
+	name string
+}
+
+func TestRegisterTyped(t *testing.T) {
+	s := chanrpc.NewServer(10)
+	s.RegisterTyped("login", func(req *loginReq, uid int) (string, error) {
+		if req == nil {
+			return "", errors.New("empty request")
+		}
+		return req.name, nil
+	})
+	s.RegisterTyped("sum", func(nums ...int) (int, int) {
+		sum := 0
+		for _, n := range nums {
+			sum += n
+		}
+		return len(nums), sum
+	})
+	go func() {
+		for ci := range s.ChanCall {
+			s.Exec(ci)
+		}
+	}()
+	defer close(s.ChanCall)
+
+	ret, err := s.Call1("login", &loginReq{name: "leaf"}, 1)
+	if err != nil || ret != "leaf" {
+		t.Fatalf("unexpected result %v, %v", ret, err)
+	}
+	if _, err = s.Call1("login", nil, 1); err == nil || err.Error() != "empty request" {
+		t.Fatalf("expected handler error, got %v", err)
+	}
+	if _, err = s.Call1("login", "leaf", 1); err == nil {
+		t.Fatal("expected argument type error")
+	}
+	if _, err = s.Call1("login", &loginReq{}); err == nil {
+		t.Fatal("expected argument count error")
+	}
+	if err = s.Call0("login", &loginReq{}, 1); err == nil {
+		t.Fatal("expected return type mismatch")
+	}
+
+	rn, err := s.CallN("sum", 1, 2, 3)
+	if err != nil || rn[0] != 3 || rn[1] != 6 {
+		t.Fatalf("unexpected result %v, %v", rn, err)
+	}
+}
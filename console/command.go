@@ -1,10 +1,12 @@
 package console
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path"
 	"runtime/pprof"
+	"sort"
 	"time"
 
 	"github.com/YiuTerran/leaf/chanrpc"
@@ -13,14 +15,10 @@ import (
 
 const (
 	profilePath = "/tmp"
+	// 外部命令的最长执行时间，避免卡死的模块拖住console
+	commandTimeout = 10 * time.Second
 )
 
-var commands = []Command{
-	new(CommandHelp),
-	new(CommandCPUProf),
-	new(CommandProf),
-}
-
 type Command interface {
 	// must goroutine safe
 	name() string
@@ -50,7 +48,9 @@ func (c *ExternalCommand) run(_args []string) string {
 		args[i] = v
 	}
 
-	ret, err := c.server.Call1(c._name, args...)
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+	ret, err := c.server.Call1Context(ctx, c._name, args...)
 	if err != nil {
 		return err.Error()
 	}
@@ -62,10 +62,11 @@ func (c *ExternalCommand) run(_args []string) string {
 	return output
 }
 
-// you must call the function before calling console.Init
-// goroutine not safe
-func Register(name string, help string, f interface{}, server *chanrpc.Server) {
-	for _, c := range commands {
+// goroutine safe
+func (con *Console) Register(name string, help string, f interface{}, server *chanrpc.Server) {
+	con.mutexCommands.Lock()
+	defer con.mutexCommands.Unlock()
+	for _, c := range con.commands {
 		if c.name() == name {
 			log.Fatal("command %v is already registered", name)
 		}
@@ -77,11 +78,130 @@ func Register(name string, help string, f interface{}, server *chanrpc.Server) {
 	c._name = name
 	c._help = help
 	c.server = server
-	commands = append(commands, c)
+	con.commands = append(con.commands, c)
+}
+
+// like Register, but takes over a command already registered by another server,
+// so that a new module instance can replace the old one before it is destroyed
+// goroutine safe
+func (con *Console) Replace(name string, help string, f interface{}, server *chanrpc.Server) {
+	con.mutexCommands.Lock()
+	defer con.mutexCommands.Unlock()
+	i := 0
+	for ; i < len(con.commands); i++ {
+		if con.commands[i].name() != name {
+			continue
+		}
+		if _, ok := con.commands[i].(*ExternalCommand); !ok {
+			log.Fatal("command %v is already registered", name)
+		}
+		break
+	}
+
+	server.Replace(name, f)
+
+	c := new(ExternalCommand)
+	c._name = name
+	c._help = help
+	c.server = server
+	if i < len(con.commands) {
+		con.commands[i] = c
+	} else {
+		con.commands = append(con.commands, c)
+	}
+}
+
+// a command running f in the console goroutine, f must be goroutine safe
+type FuncCommand struct {
+	_name string
+	_help string
+	f     func(args []string) string
+}
+
+func (c *FuncCommand) name() string {
+	return c._name
+}
+
+func (c *FuncCommand) help() string {
+	return c._help
+}
+
+func (c *FuncCommand) run(args []string) string {
+	return c.f(args)
+}
+
+// goroutine safe
+func (con *Console) RegisterFunc(name string, help string, f func(args []string) string) {
+	con.mutexCommands.Lock()
+	defer con.mutexCommands.Unlock()
+	for _, c := range con.commands {
+		if c.name() == name {
+			log.Fatal("command %v is already registered", name)
+		}
+	}
+
+	c := new(FuncCommand)
+	c._name = name
+	c._help = help
+	c.f = f
+	con.commands = append(con.commands, c)
+}
+
+// remove a command added by Register or RegisterFunc, so that it can be registered again
+// goroutine safe
+func (con *Console) Unregister(name string) bool {
+	con.mutexCommands.Lock()
+	defer con.mutexCommands.Unlock()
+	for i, c := range con.commands {
+		switch c := c.(type) {
+		case *ExternalCommand:
+			if c._name != name {
+				continue
+			}
+			c.server.Unregister(name)
+		case *FuncCommand:
+			if c._name != name {
+				continue
+			}
+		default:
+			continue
+		}
+		con.commands = append(con.commands[:i:i], con.commands[i+1:]...)
+		return true
+	}
+	return false
+}
+
+// remove a command added by Register or Replace only if it still runs on server
+// goroutine safe
+func (con *Console) UnregisterFrom(name string, server *chanrpc.Server) bool {
+	con.mutexCommands.Lock()
+	defer con.mutexCommands.Unlock()
+	for i, c := range con.commands {
+		if c, ok := c.(*ExternalCommand); ok && c._name == name && c.server == server {
+			c.server.Unregister(name)
+			con.commands = append(con.commands[:i:i], con.commands[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (con *Console) findCommand(name string) Command {
+	con.mutexCommands.RLock()
+	defer con.mutexCommands.RUnlock()
+	for _, c := range con.commands {
+		if c.name() == name {
+			return c
+		}
+	}
+	return nil
 }
 
 // help
-type CommandHelp struct{}
+type CommandHelp struct {
+	con *Console
+}
 
 func (c *CommandHelp) name() string {
 	return "help"
@@ -93,9 +213,11 @@ func (c *CommandHelp) help() string {
 
 func (c *CommandHelp) run([]string) string {
 	output := "Commands:\r\n"
-	for _, c := range commands {
+	c.con.mutexCommands.RLock()
+	for _, c := range c.con.commands {
 		output += c.name() + " - " + c.help() + "\r\n"
 	}
+	c.con.mutexCommands.RUnlock()
 	output += "quit - exit console"
 
 	return output
@@ -217,3 +339,48 @@ func (c *CommandProf) run(args []string) string {
 
 	return fn
 }
+
+// rpcstat
+type CommandRPCStat struct {
+	con *Console
+}
+
+func (c *CommandRPCStat) name() string {
+	return "rpcstat"
+}
+
+func (c *CommandRPCStat) help() string {
+	return "chanrpc statistics of the registered servers"
+}
+
+func (c *CommandRPCStat) usage() string {
+	return "rpcstat prints queue depth, dropped calls and per-function\r\n" +
+		"calls, errors and latency of chanrpc servers\r\n\r\n" +
+		"Usage: rpcstat [name]\r\n" +
+		"  name - only print the server with the name"
+}
+
+func (c *CommandRPCStat) run(args []string) string {
+	servers := c.con.servers.Servers()
+	if len(args) > 0 {
+		s, ok := servers[args[0]]
+		if !ok {
+			return c.usage()
+		}
+		return args[0] + " " + s.Stat().String()
+	}
+
+	names := make([]string, 0, len(servers))
+	for name := range servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	output := ""
+	for i, name := range names {
+		if i > 0 {
+			output += "\r\n"
+		}
+		output += name + " " + servers[name].Stat().String()
+	}
+	return output
+}
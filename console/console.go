@@ -5,7 +5,9 @@ import (
 	"math"
 	"strconv"
 	"strings"
+	"sync"
 
+	"github.com/YiuTerran/leaf/chanrpc"
 	"github.com/YiuTerran/leaf/network"
 	"github.com/YiuTerran/leaf/network/tcp"
 )
@@ -14,35 +16,109 @@ const (
 	consolePrompt = "leaf#"
 )
 
-var server *tcp.Server
+// a console with its own commands and tcp server,
+// the package functions use the default one
+type Console struct {
+	commands      []Command
+	mutexCommands sync.RWMutex
+	server        *tcp.Server
+	servers       *chanrpc.Registry
+}
 
-func Init(consolePort int) {
+// a console with its own registry of named servers
+func New() *Console {
+	return newConsole(chanrpc.NewRegistry())
+}
+
+func newConsole(servers *chanrpc.Registry) *Console {
+	con := new(Console)
+	con.servers = servers
+	con.commands = []Command{
+		&CommandHelp{con},
+		new(CommandCPUProf),
+		new(CommandProf),
+		&CommandRPCStat{con},
+	}
+	return con
+}
+
+// the default console prints the servers of chanrpc.RegisterServer
+var std = newConsole(chanrpc.DefaultRegistry())
+
+// the console used by the package functions
+func Default() *Console {
+	return std
+}
+
+// the named servers printed by rpcstat
+func (con *Console) Servers() *chanrpc.Registry {
+	return con.servers
+}
+
+func (con *Console) Init(consolePort int) {
 	if consolePort == 0 {
 		return
 	}
-	server = new(tcp.Server)
-	server.Addr = "localhost:" + strconv.Itoa(consolePort)
-	server.MaxConnNum = math.MaxInt32
-	server.PendingWriteNum = 100
-	server.NewAgent = newAgent
+	con.server = new(tcp.Server)
+	con.server.Addr = "localhost:" + strconv.Itoa(consolePort)
+	con.server.MaxConnNum = math.MaxInt32
+	con.server.PendingWriteNum = 100
+	con.server.NewAgent = func(conn *tcp.Conn) network.Agent {
+		return newAgent(con, conn)
+	}
 
-	server.Start()
+	con.server.Start()
 }
 
-func Destroy() {
-	if server != nil {
-		server.Close()
-		server = nil
+func (con *Console) Destroy() {
+	if con.server != nil {
+		con.server.Close()
+		con.server = nil
 	}
 }
 
+func Init(consolePort int) {
+	std.Init(consolePort)
+}
+
+func Destroy() {
+	std.Destroy()
+}
+
+// goroutine safe
+func Register(name string, help string, f interface{}, server *chanrpc.Server) {
+	std.Register(name, help, f, server)
+}
+
+// goroutine safe
+func Replace(name string, help string, f interface{}, server *chanrpc.Server) {
+	std.Replace(name, help, f, server)
+}
+
+// goroutine safe
+func RegisterFunc(name string, help string, f func(args []string) string) {
+	std.RegisterFunc(name, help, f)
+}
+
+// goroutine safe
+func Unregister(name string) bool {
+	return std.Unregister(name)
+}
+
+// goroutine safe
+func UnregisterFrom(name string, server *chanrpc.Server) bool {
+	return std.UnregisterFrom(name, server)
+}
+
 type Agent struct {
+	con    *Console
 	conn   *tcp.Conn
 	reader *bufio.Reader
 }
 
-func newAgent(conn *tcp.Conn) network.Agent {
+func newAgent(con *Console, conn *tcp.Conn) network.Agent {
 	a := new(Agent)
+	a.con = con
 	a.conn = conn
 	a.reader = bufio.NewReader(conn)
 	return a
@@ -64,13 +140,7 @@ func (a *Agent) Run() {
 		if args[0] == "quit" {
 			break
 		}
-		var c Command
-		for _, _c := range commands {
-			if _c.name() == args[0] {
-				c = _c
-				break
-			}
-		}
+		c := a.con.findCommand(args[0])
 		if c == nil {
 			a.conn.Write([]byte("command not found, try `help` for help\r\n"))
 			continue
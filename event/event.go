@@ -0,0 +1,107 @@
+package event
+
+import (
+	"sync"
+
+	"github.com/YiuTerran/leaf/chanrpc"
+)
+
+//基于chanrpc的进程内发布订阅
+//订阅者在自己的chanrpc.Server上注册处理函数，发布时通过Go投递到每个订阅者，
+//所以处理函数总是在订阅者自己的goroutine中执行
+
+//话题在chanrpc.Server中的function id，与用户的id不会冲突
+type topicID string
+
+func ID(topic string) interface{} {
+	return topicID(topic)
+}
+
+type Bus struct {
+	sync.RWMutex
+	subs map[string]map[*chanrpc.Server]struct{}
+}
+
+func NewBus() *Bus {
+	b := new(Bus)
+	b.subs = make(map[string]map[*chanrpc.Server]struct{})
+	return b
+}
+
+//handler必须已经以ID(topic)注册在server上
+// goroutine safe
+func (b *Bus) Subscribe(topic string, server *chanrpc.Server) {
+	b.Lock()
+	defer b.Unlock()
+	ss, ok := b.subs[topic]
+	if !ok {
+		ss = make(map[*chanrpc.Server]struct{})
+		b.subs[topic] = ss
+	}
+	ss[server] = struct{}{}
+}
+
+// goroutine safe
+func (b *Bus) Unsubscribe(topic string, server *chanrpc.Server) {
+	b.Lock()
+	defer b.Unlock()
+	if ss, ok := b.subs[topic]; ok {
+		delete(ss, server)
+		if len(ss) == 0 {
+			delete(b.subs, topic)
+		}
+	}
+}
+
+//取消server的所有订阅，模块销毁时调用
+// goroutine safe
+func (b *Bus) UnsubscribeAll(server *chanrpc.Server) {
+	b.Lock()
+	defer b.Unlock()
+	for topic, ss := range b.subs {
+		delete(ss, server)
+		if len(ss) == 0 {
+			delete(b.subs, topic)
+		}
+	}
+}
+
+//投递给所有订阅者，返回订阅者数量
+//投递使用各订阅者的Go，注意不要在无缓冲的server自己的goroutine中发布给自己
+// goroutine safe
+func (b *Bus) Publish(topic string, args ...interface{}) int {
+	b.RLock()
+	ss := make([]*chanrpc.Server, 0, len(b.subs[topic]))
+	for s := range b.subs[topic] {
+		ss = append(ss, s)
+	}
+	b.RUnlock()
+
+	for _, s := range ss {
+		s.Go(topicID(topic), args...)
+	}
+	return len(ss)
+}
+
+var defaultBus = NewBus()
+
+// the bus used by the package functions
+func Default() *Bus {
+	return defaultBus
+}
+
+func Subscribe(topic string, server *chanrpc.Server) {
+	defaultBus.Subscribe(topic, server)
+}
+
+func Unsubscribe(topic string, server *chanrpc.Server) {
+	defaultBus.Unsubscribe(topic, server)
+}
+
+func UnsubscribeAll(server *chanrpc.Server) {
+	defaultBus.UnsubscribeAll(server)
+}
+
+func Publish(topic string, args ...interface{}) int {
+	return defaultBus.Publish(topic, args...)
+}
@@ -0,0 +1,37 @@
+package event_test
+
+import (
+	"fmt"
+
+	"github.com/YiuTerran/leaf/chanrpc"
+	"github.com/YiuTerran/leaf/event"
+)
+
+func Example() {
+	login := chanrpc.NewServer(10)
+	login.Register(event.ID("PlayerLogin"), func(args []interface{}) {
+		fmt.Println("login:", args[0])
+	})
+	event.Subscribe("PlayerLogin", login)
+
+	game := chanrpc.NewServer(10)
+	game.RegisterTyped(event.ID("PlayerLogin"), func(name string) {
+		fmt.Println("game:", name)
+	})
+	event.Subscribe("PlayerLogin", game)
+
+	fmt.Println(event.Publish("PlayerLogin", "leaf"))
+	login.Exec(<-login.ChanCall)
+	game.Exec(<-game.ChanCall)
+
+	event.UnsubscribeAll(game)
+	fmt.Println(event.Publish("PlayerLogin", "leaf"))
+	login.Exec(<-login.ChanCall)
+
+	// Output:
+	// 2
+	// login: leaf
+	// game: leaf
+	// 1
+	// login: leaf
+}
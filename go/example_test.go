@@ -68,3 +68,36 @@ func ExampleLinearContext() {
 	// 1
 	// 2
 }
+
+func ExampleKeyedContext() {
+	d := g.New(10)
+	c := d.NewKeyedContext(2)
+
+	// linear for the same key
+	c.Go("a", func() {
+		time.Sleep(time.Second / 2)
+		fmt.Println("a1")
+	}, nil)
+	c.Go("a", func() {
+		fmt.Println("a2")
+	}, nil)
+	d.Cb(<-d.ChanCb)
+	d.Cb(<-d.ChanCb)
+
+	// parallel for different keys
+	c.Go("a", func() {
+		time.Sleep(time.Second / 2)
+		fmt.Println("a3")
+	}, nil)
+	c.Go("b", func() {
+		fmt.Println("b1")
+	}, nil)
+
+	d.Close()
+
+	// Output:
+	// a1
+	// a2
+	// b1
+	// a3
+}
@@ -0,0 +1,94 @@
+package g
+
+import (
+	"container/list"
+	"runtime"
+	"sync"
+
+	"github.com/YiuTerran/leaf/log"
+)
+
+//同一个key的任务按提交顺序串行执行，不同key的任务在最多workers个goroutine上并行
+//Go只能在g所在的goroutine中调用
+type KeyedContext struct {
+	g       *Go
+	workers int
+	active  int
+	mutex   sync.Mutex
+	queues  map[interface{}]*list.List //有任务等待或者正在执行的key
+	ready   *list.List                 //可以执行的key，同一个key同时最多出现一次
+}
+
+func (g *Go) NewKeyedContext(workers int) *KeyedContext {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	c := new(KeyedContext)
+	c.g = g
+	c.workers = workers
+	c.queues = make(map[interface{}]*list.List)
+	c.ready = list.New()
+	return c
+}
+
+func (c *KeyedContext) Go(key interface{}, f func(), cb func()) {
+	c.g.pendingGo++
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	q, ok := c.queues[key]
+	if !ok {
+		q = list.New()
+		c.queues[key] = q
+	}
+	q.PushBack(&LinearGo{f: f, cb: cb})
+	//key正在执行时由执行完的worker重新放回ready
+	if ok {
+		return
+	}
+	c.ready.PushBack(key)
+	if c.active < c.workers {
+		c.active++
+		go c.work()
+	}
+}
+
+func (c *KeyedContext) work() {
+	for {
+		c.mutex.Lock()
+		if c.ready.Len() == 0 {
+			c.active--
+			c.mutex.Unlock()
+			return
+		}
+		key := c.ready.Remove(c.ready.Front())
+		e := c.queues[key].Front().Value.(*LinearGo)
+		c.mutex.Unlock()
+
+		c.exec(e)
+
+		c.mutex.Lock()
+		q := c.queues[key]
+		q.Remove(q.Front())
+		if q.Len() == 0 {
+			delete(c.queues, key)
+		} else {
+			//放到队尾，避免一个key占住worker
+			c.ready.PushBack(key)
+		}
+		c.mutex.Unlock()
+	}
+}
+
+func (c *KeyedContext) exec(e *LinearGo) {
+	defer func() {
+		c.g.ChanCb <- e.cb
+		if r := recover(); r != nil {
+			buf := make([]byte, log.LenStackBuf)
+			l := runtime.Stack(buf, false)
+			log.Error("%v: %s", r, buf[:l])
+		}
+	}()
+
+	e.f()
+}
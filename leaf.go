@@ -1,78 +1,343 @@
 package leaf
 
 import (
+	"errors"
 	"os"
-	"os/signal"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/YiuTerran/leaf/console"
 	"github.com/YiuTerran/leaf/log"
 	"github.com/YiuTerran/leaf/module"
+	"github.com/YiuTerran/leaf/util/fs"
 )
 
-var (
-	closeChannel    = make(chan os.Signal, 1)
-	internalChannel = make(chan int, 1)
-
+const (
 	quitSig   = 1
 	reloadSig = 2
 )
 
+var (
+	ErrRunning    = errors.New("leaf: app is already running")
+	ErrNotRunning = errors.New("leaf: app is not running")
+)
+
 type GetModules func() map[module.Action][]module.Module
 
-//手动关闭服务
-func CloseServer() {
-	closeChannel <- os.Kill
+//一个服务器实例，拥有自己的模块管理器、console、事件总线、信号处理和日志
+//模块管理和App自身的日志使用App的Logger，chanrpc、Skeleton等包内部的日志仍然输出到log包的默认实例
+type App struct {
+	ConsolePort int
+	LogPath     string //NewApp创建的实例在Run时按LogPath创建自己的Logger；默认实例非空时初始化log包，否则需要在Run之前调用log.InitLogger
+	BeforeClose func() //关闭模块之前调用
+	ForceExit   bool   //有模块没有在超时之前退出时，刷新日志后强制退出进程
+
+	modules   *module.Manager
+	console   *console.Console
+	logger    *log.Logger
+	ownLogger bool
+
+	signalActions map[os.Signal]SignalAction
+	signalHooks   map[os.Signal]func()
+	mutexSignals  sync.Mutex
+
+	mutexRun        sync.Mutex
+	running         bool
+	stopping        bool
+	closeChannel    chan os.Signal
+	internalChannel chan int
+	closing         chan struct{}
+	done            chan struct{}
+	err             error
 }
 
-//内部热加载
-func ReloadServer() {
-	internalChannel <- reloadSig
+//创建一个使用独立模块管理器、console、事件总线和Logger的实例
+func NewApp() *App {
+	mgr := module.NewManager()
+	a := newApp(mgr, mgr.Console())
+	a.ownLogger = true
+	return a
+}
+
+func newApp(modules *module.Manager, con *console.Console) *App {
+	a := new(App)
+	a.modules = modules
+	a.console = con
+	a.signalActions = make(map[os.Signal]SignalAction)
+	a.signalHooks = make(map[os.Signal]func())
+	a.defaultSignals()
+	a.reset()
+	return a
+}
+
+func (a *App) reset() {
+	a.closeChannel = make(chan os.Signal, 1)
+	a.internalChannel = make(chan int, 1)
+	a.closing = make(chan struct{})
+	a.done = make(chan struct{})
+	a.stopping = false
+}
+
+//模块管理器，模块的Skeleton需要注册命令时应当使用Console
+func (a *App) Modules() *module.Manager {
+	return a.modules
+}
+
+func (a *App) Console() *console.Console {
+	return a.console
+}
+
+//App使用的Logger，第一次Run之前为log包的默认实例
+func (a *App) Logger() *log.Logger {
+	a.mutexRun.Lock()
+	defer a.mutexRun.Unlock()
+	return a.log()
+}
+
+func (a *App) log() *log.Logger {
+	if a.logger == nil {
+		return log.Default()
+	}
+	return a.logger
+}
+
+//按LogPath准备Logger，Run开始时调用
+func (a *App) initLogger() {
+	a.mutexRun.Lock()
+	defer a.mutexRun.Unlock()
+	if !a.ownLogger {
+		if a.LogPath != "" {
+			log.InitLogger(a.LogPath)
+		}
+		a.logger = log.Default()
+		return
+	}
+	if a.logger == nil || a.logger.Path() != a.LogPath {
+		a.logger = log.New(a.LogPath)
+	}
+}
+
+//开始关闭服务，不等待关闭完成，已经在关闭或者没有运行时返回false
+func (a *App) stop(sig os.Signal) bool {
+	a.mutexRun.Lock()
+	defer a.mutexRun.Unlock()
+	if !a.running || a.stopping {
+		return false
+	}
+	a.stopping = true
+	a.closeChannel <- sig
+	return true
+}
+
+//关闭服务并等待Run返回，返回值同Run
+func (a *App) Shutdown() error {
+	a.mutexRun.Lock()
+	running, done := a.running, a.done
+	a.mutexRun.Unlock()
+	if !running {
+		return ErrNotRunning
+	}
+	a.stop(os.Interrupt)
+	<-done
+	a.mutexRun.Lock()
+	defer a.mutexRun.Unlock()
+	return a.err
+}
+
+//热加载，已经有等待中的热加载时忽略
+func (a *App) Reload() {
+	a.mutexRun.Lock()
+	ch := a.internalChannel
+	a.mutexRun.Unlock()
+	select {
+	case ch <- reloadSig:
+	default:
+	}
+}
+
+func (a *App) destroy() error {
+	a.console.Destroy()
+	unfinished := a.modules.Destroy()
+	a.log().Info("Server closing down")
+	if len(unfinished) == 0 {
+		return nil
+	}
+	if a.ForceExit {
+		a.log().Close()
+		os.Exit(1)
+	}
+	return errors.New("leaf: modules not stopped in time: " + strings.Join(unfinished, ", "))
+}
+
+//模块panic且不再重启时关闭服务
+func (a *App) escalate(name string) {
+	a.log().Error("module %s stopped, closing server", name)
+	a.stop(os.Kill)
 }
 
 //热加载
-func reload(getMods GetModules) {
+func (a *App) reload(getMods GetModules, internalChannel chan int) {
 	for {
 		sig := <-internalChannel
 		if sig == quitSig {
 			break
 		} else if sig == reloadSig {
-			module.Reload(getMods())
+			_ = a.modules.Reload(getMods())
 		}
 	}
 }
 
-//一般运行模式：开启模块热加载特性
-func Run(consolePort int, getMods GetModules, beforeClose func()) {
-	//注意在此之前要调用log.InitLogger
-	log.Info("Server %v starting up", version)
+//周期性检查配置文件，有变化时热加载，在Run之前调用，Run返回时停止
+//GetModules或者模块的Version应当根据新的配置返回结果
+func (a *App) WatchConfig(parsers map[string]fs.IConfigParser, interval time.Duration) {
+	a.mutexRun.Lock()
+	closing := a.closing
+	a.mutexRun.Unlock()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-closing:
+				return
+			case <-ticker.C:
+				if fs.WatchConfigFiles(parsers) {
+					a.Reload()
+				}
+			}
+		}
+	}()
+}
+
+//一般运行模式：开启模块热加载特性，阻塞到服务关闭
+//初始加载模块失败或者有模块没有在超时之前退出时返回错误
+func (a *App) Run(getMods GetModules) error {
+	return a.run(false, getMods, func() error {
+		return a.modules.Reload(getMods())
+	})
+}
+
+//根据期望的模块列表自动对比Version热加载，其他同Run
+func (a *App) AutoRun(getMods func() []module.Module) error {
+	return a.Run(func() map[module.Action][]module.Module {
+		return a.modules.Diff(getMods())
+	})
+}
+
+//模块以静态模式加载（关闭热加载特性）
+func (a *App) StaticRun(mods []module.Module) error {
+	return a.run(true, nil, func() error {
+		a.modules.StaticLoad(mods)
+		return nil
+	})
+}
+
+func (a *App) run(static bool, getMods GetModules, load func() error) (err error) {
+	a.mutexRun.Lock()
+	if a.running {
+		a.mutexRun.Unlock()
+		return ErrRunning
+	}
+	a.running = true
+	closeChannel, internalChannel, closing := a.closeChannel, a.internalChannel, a.closing
+	a.mutexRun.Unlock()
+	defer func() {
+		a.mutexRun.Lock()
+		defer a.mutexRun.Unlock()
+		a.running = false
+		a.err = err
+		if a.ownLogger && a.logger != nil {
+			a.logger.Close()
+		}
+		close(a.done)
+		a.reset()
+	}()
+
+	a.initLogger()
+	//注意在此之前要初始化日志
+	a.log().Info("Server %v starting up", version)
+	a.modules.SetLogger(a.log())
+	a.modules.SetEscalation(a.escalate)
 	// module
-	module.Reload(getMods())
+	if err := load(); err != nil {
+		close(closing)
+		a.modules.Destroy()
+		return err
+	}
 	// console
-	console.Init(consolePort)
-	//注册热加载信号
-	go reload(getMods)
+	a.modules.RegisterCommands(a.console)
+	defer a.modules.UnregisterCommands(a.console)
+	if !static {
+		a.console.RegisterFunc("reload", "reload the modules", func([]string) string {
+			a.Reload()
+			return "reloading"
+		})
+		defer a.console.Unregister("reload")
+		go a.reload(getMods, internalChannel)
+	}
+	a.console.Init(a.ConsolePort)
 	//关闭&&重启
-	signal.Notify(closeChannel, os.Interrupt, os.Kill)
+	stopSignals := a.notifySignals(static)
+	defer stopSignals()
 	<-closeChannel
-	internalChannel <- quitSig
-	if beforeClose != nil {
-		beforeClose()
+	close(closing)
+	if !static {
+		internalChannel <- quitSig
+	}
+	if a.BeforeClose != nil {
+		a.BeforeClose()
+	}
+	return a.destroy()
+}
+
+//包级函数使用的默认实例，使用module和console包的默认实例
+var std = newApp(module.Default(), console.Default())
+
+//手动关闭服务
+func CloseServer() {
+	std.stop(os.Kill)
+}
+
+//内部热加载，已经有等待中的热加载时忽略
+func ReloadServer() {
+	std.Reload()
+}
+
+//有模块没有在超时之前退出时，刷新日志后强制退出进程
+//超时通过module.SetShutdownTimeout设置
+func SetForceExit(option bool) {
+	std.ForceExit = option
+}
+
+//见App.WatchConfig
+func WatchConfig(parsers map[string]fs.IConfigParser, interval time.Duration) {
+	std.WatchConfig(parsers, interval)
+}
+
+//根据期望的模块列表自动对比Version热加载，其他同Run
+func AutoRun(consolePort int, getMods func() []module.Module, beforeClose func()) {
+	std.ConsolePort = consolePort
+	std.BeforeClose = beforeClose
+	if err := std.AutoRun(getMods); err != nil {
+		log.Error("%v", err)
+	}
+}
+
+//一般运行模式：开启模块热加载特性
+func Run(consolePort int, getMods GetModules, beforeClose func()) {
+	std.ConsolePort = consolePort
+	std.BeforeClose = beforeClose
+	if err := std.Run(getMods); err != nil {
+		log.Error("%v", err)
 	}
-	signal.Stop(closeChannel)
-	console.Destroy()
-	module.Destroy()
-	log.Info("Server closing down")
 }
 
 //模块以静态模式加载（关闭热加载特性）
 func StaticRun(consolePort int, mods []module.Module) {
-	//注意在此之前要调用log.InitLogger
-	log.Info("Server %v starting up", version)
-	module.StaticLoad(mods)
-	console.Init(consolePort)
-	signal.Notify(closeChannel, os.Interrupt, os.Kill)
-	<-closeChannel
-	console.Destroy()
-	module.Destroy()
-	log.Info("Server closing down")
+	std.ConsolePort = consolePort
+	std.BeforeClose = nil
+	if err := std.StaticRun(mods); err != nil {
+		log.Error("%v", err)
+	}
 }
@@ -19,48 +19,91 @@ const (
 	LenStackBuf = 4096
 )
 
-var (
+//一组日志输出，包级函数使用InitLogger创建的默认实例
+// goroutine safe
+type Logger struct {
 	tracker      *zap.Logger
 	normalLogger *zap.SugaredLogger
 	debugLogger  *zap.SugaredLogger
 	logger       atomic.Value
 	logPath      string
-	infoWriter   io.Writer
-	warnWriter   io.Writer
-	once         sync.Once
+	writers      []io.Writer //文件输出，Reopen时切换
+	debug        zap.AtomicLevel
+}
+
+var (
+	std  atomic.Value
+	once sync.Once
 
-	debug  = zap.NewAtomicLevelAt(zap.DebugLevel)
 	inited = atomic.NewBool(false)
 )
 
+//包级函数使用的实例，InitLogger之前为nil
+func Default() *Logger {
+	l, _ := std.Load().(*Logger)
+	return l
+}
+
+func (l *Logger) sugar() *zap.SugaredLogger {
+	return l.logger.Load().(*zap.SugaredLogger)
+}
+
 //调试模式下打印caller，其他忽略，减少开销
 func Debug(format string, a ...interface{}) {
-	logger.Load().(*zap.SugaredLogger).Debugf(format, a...)
+	Default().sugar().Debugf(format, a...)
 }
 
 func Info(format string, a ...interface{}) {
-	logger.Load().(*zap.SugaredLogger).Infof(format, a...)
+	Default().sugar().Infof(format, a...)
 }
 
 func Warn(format string, a ...interface{}) {
-	logger.Load().(*zap.SugaredLogger).Warnf(format, a...)
+	Default().sugar().Warnf(format, a...)
 }
 
 func Error(format string, a ...interface{}) {
-	logger.Load().(*zap.SugaredLogger).Errorf(format, a...)
+	Default().sugar().Errorf(format, a...)
 }
 
 func Fatal(format string, a ...interface{}) {
-	logger.Load().(*zap.SugaredLogger).Fatalf(format, a...)
+	Default().sugar().Fatalf(format, a...)
 }
 
 //输出json的track
 func Track(msg string, fields ...zap.Field) {
-	tracker.Info(msg, fields...)
+	Default().tracker.Info(msg, fields...)
 }
 
 func GetLogPath() string {
-	return logPath
+	return Default().logPath
+}
+
+func (l *Logger) Debug(format string, a ...interface{}) {
+	l.sugar().Debugf(format, a...)
+}
+
+func (l *Logger) Info(format string, a ...interface{}) {
+	l.sugar().Infof(format, a...)
+}
+
+func (l *Logger) Warn(format string, a ...interface{}) {
+	l.sugar().Warnf(format, a...)
+}
+
+func (l *Logger) Error(format string, a ...interface{}) {
+	l.sugar().Errorf(format, a...)
+}
+
+func (l *Logger) Fatal(format string, a ...interface{}) {
+	l.sugar().Fatalf(format, a...)
+}
+
+func (l *Logger) Track(msg string, fields ...zap.Field) {
+	l.tracker.Info(msg, fields...)
+}
+
+func (l *Logger) Path() string {
+	return l.logPath
 }
 
 //辅助函数，Track中使用zap.Any打印Object之前将其转换为原始json
@@ -79,13 +122,41 @@ func timeEncoder(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
 
 //激活debug等级
 func EnableDebug(option bool) {
+	Default().EnableDebug(option)
+}
+
+func IsDebug() bool {
+	return Default().IsDebug()
+}
+
+//立即切换到新的日志文件，一般在外部移动日志文件之后调用
+func Reopen() error {
+	return Default().Reopen()
+}
+
+func (l *Logger) EnableDebug(option bool) {
 	if option {
-		debug.SetLevel(zap.DebugLevel)
-		logger.Store(debugLogger)
+		l.debug.SetLevel(zap.DebugLevel)
+		l.logger.Store(l.debugLogger)
 	} else {
-		debug.SetLevel(zap.InfoLevel)
-		logger.Store(normalLogger)
+		l.debug.SetLevel(zap.InfoLevel)
+		l.logger.Store(l.normalLogger)
+	}
+}
+
+func (l *Logger) IsDebug() bool {
+	return l.debug.Enabled(zap.DebugLevel)
+}
+
+func (l *Logger) Reopen() error {
+	for _, w := range l.writers {
+		if rl, ok := w.(*rotate.RotateLogs); ok {
+			if err := rl.Rotate(); err != nil {
+				return err
+			}
+		}
 	}
+	return nil
 }
 
 func IsInit() bool {
@@ -104,62 +175,76 @@ func exists(path string) bool {
 	return true
 }
 
-//path是一个文件夹路径，自动生成track.json, service.log, err.log
-//其他的输出到标准输出和标准错误
+//初始化包级函数使用的默认实例，只有第一次调用生效，见New
 func InitLogger(path string) {
 	once.Do(func() {
-		//空白路径，只在控制台输出，方便调试
-		if path == "" {
-			lg, err := zap.NewDevelopment()
-			if err != nil {
-				return
-			}
-			normalLogger = lg.Sugar()
-			debugLogger = lg.Sugar()
-			tracker = lg
-			EnableDebug(true)
+		l := New(path)
+		if l == nil {
 			return
 		}
-		if !exists(path) && os.Mkdir(path, os.ModePerm) != nil {
-			panic("fail to create log directory")
+		std.Store(l)
+		inited.Store(path != "")
+	})
+}
+
+//path是一个文件夹路径，自动生成track.json, service.log, err.log
+//其他的输出到标准输出和标准错误
+//空白路径只在控制台输出，方便调试
+func New(path string) *Logger {
+	l := new(Logger)
+	l.debug = zap.NewAtomicLevelAt(zap.DebugLevel)
+	if path == "" {
+		lg, err := zap.NewDevelopment()
+		if err != nil {
+			return nil
 		}
-		logPath = path
-		encoderCfg := zap.NewProductionEncoderConfig()
-		encoderCfg.TimeKey = "@timestamp"
-		encoderCfg.EncodeTime = timeEncoder
-		tracker = zap.New(
-			zapcore.NewCore(zapcore.NewJSONEncoder(encoderCfg),
-				zapcore.AddSync(getWriter(filepath.Join(path, "track"))),
-				zap.InfoLevel))
-		//高优先级
-		hp := zap.LevelEnablerFunc(func(lvl zapcore.Level) bool {
-			return lvl >= zapcore.WarnLevel
-		})
-		//所有
-		all := zap.LevelEnablerFunc(func(lvl zapcore.Level) bool {
-			if debug.Enabled(zap.DebugLevel) {
-				return true
-			}
-			return lvl > zap.DebugLevel
-		})
-		//都输出到标准输出，方便调试
-		warnWriter = getWriter(filepath.Join(path, "error.log"))
-		infoWriter = getWriter(filepath.Join(path, "service.log"))
-		consoleConfig := zap.NewDevelopmentEncoderConfig()
-		encoder := zapcore.NewConsoleEncoder(consoleConfig)
-		core := zapcore.NewTee(
-			// 将info及以下写入logPath,  warn及以上写入errPath
-			zapcore.NewCore(encoder, zapcore.AddSync(infoWriter), all),
-			zapcore.NewCore(encoder, zapcore.AddSync(warnWriter), hp),
-			//同步到stdout，方便调试
-			zapcore.NewCore(encoder, zapcore.AddSync(os.Stdout), all),
-		)
-		lg := zap.New(core)
-		normalLogger = lg.Sugar()
-		debugLogger = lg.WithOptions(zap.AddCaller(), zap.AddCallerSkip(1)).Sugar()
-		EnableDebug(true)
-		inited.Store(true)
+		l.normalLogger = lg.Sugar()
+		l.debugLogger = lg.Sugar()
+		l.tracker = lg
+		l.EnableDebug(true)
+		return l
+	}
+	if !exists(path) && os.Mkdir(path, os.ModePerm) != nil {
+		panic("fail to create log directory")
+	}
+	l.logPath = path
+	encoderCfg := zap.NewProductionEncoderConfig()
+	encoderCfg.TimeKey = "@timestamp"
+	encoderCfg.EncodeTime = timeEncoder
+	trackWriter := getWriter(filepath.Join(path, "track"))
+	l.tracker = zap.New(
+		zapcore.NewCore(zapcore.NewJSONEncoder(encoderCfg),
+			zapcore.AddSync(trackWriter),
+			zap.InfoLevel))
+	//高优先级
+	hp := zap.LevelEnablerFunc(func(lvl zapcore.Level) bool {
+		return lvl >= zapcore.WarnLevel
 	})
+	//所有
+	all := zap.LevelEnablerFunc(func(lvl zapcore.Level) bool {
+		if l.debug.Enabled(zap.DebugLevel) {
+			return true
+		}
+		return lvl > zap.DebugLevel
+	})
+	//都输出到标准输出，方便调试
+	warnWriter := getWriter(filepath.Join(path, "error.log"))
+	infoWriter := getWriter(filepath.Join(path, "service.log"))
+	l.writers = []io.Writer{infoWriter, warnWriter, trackWriter}
+	consoleConfig := zap.NewDevelopmentEncoderConfig()
+	encoder := zapcore.NewConsoleEncoder(consoleConfig)
+	core := zapcore.NewTee(
+		// 将info及以下写入logPath,  warn及以上写入errPath
+		zapcore.NewCore(encoder, zapcore.AddSync(infoWriter), all),
+		zapcore.NewCore(encoder, zapcore.AddSync(warnWriter), hp),
+		//同步到stdout，方便调试
+		zapcore.NewCore(encoder, zapcore.AddSync(os.Stdout), all),
+	)
+	lg := zap.New(core)
+	l.normalLogger = lg.Sugar()
+	l.debugLogger = lg.WithOptions(zap.AddCaller(), zap.AddCallerSkip(1)).Sugar()
+	l.EnableDebug(true)
+	return l
 }
 
 func getWriter(filename string) io.Writer {
@@ -178,11 +263,19 @@ func getWriter(filename string) io.Writer {
 }
 
 func GetOriginLogger() *zap.SugaredLogger {
-	return normalLogger
+	return Default().normalLogger
+}
+
+func (l *Logger) OriginLogger() *zap.SugaredLogger {
+	return l.normalLogger
 }
 
 //关闭服务器之前调用，同步缓冲区
 func CloseLogger() {
-	_ = normalLogger.Sync()
-	_ = tracker.Sync()
+	Default().Close()
+}
+
+func (l *Logger) Close() {
+	_ = l.normalLogger.Sync()
+	_ = l.tracker.Sync()
 }
@@ -16,3 +16,15 @@ func TestLogger(t *testing.T) {
 	Debug("should not print")
 	CloseLogger()
 }
+
+func TestNew(t *testing.T) {
+	InitLogger("")
+	EnableDebug(true)
+	l := New("")
+	l.EnableDebug(false)
+	if l.IsDebug() || !IsDebug() {
+		t.Fatal("expected independent debug levels")
+	}
+	l.Info("hello %v", "logger")
+	l.Close()
+}
@@ -0,0 +1,70 @@
+package module
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/YiuTerran/leaf/chanrpc"
+)
+
+//以下函数操作默认的管理器，见Manager的同名方法
+
+func Reload(actionMds map[Action][]Module) error {
+	return std.Reload(actionMds)
+}
+
+func Diff(desired []Module) map[Action][]Module {
+	return std.Diff(desired)
+}
+
+func Sync(desired []Module) error {
+	return std.Sync(desired)
+}
+
+func StaticLoad(mis []Module) {
+	std.StaticLoad(mis)
+}
+
+func SetShutdownTimeout(perModule time.Duration, total time.Duration) {
+	std.SetShutdownTimeout(perModule, total)
+}
+
+func Destroy() (unfinished []string) {
+	return std.Destroy()
+}
+
+func SetEscalation(f func(name string)) {
+	std.SetEscalation(f)
+}
+
+func Restarts(name string) int {
+	return std.Restarts(name)
+}
+
+// goroutine safe
+func List() []Info {
+	return std.List()
+}
+
+// goroutine safe
+func Lookup(name string) (Info, bool) {
+	return std.Lookup(name)
+}
+
+// goroutine safe
+func RPCServer(name string) *chanrpc.Server {
+	return std.RPCServer(name)
+}
+
+func ReloadModule(name string) error {
+	return std.ReloadModule(name)
+}
+
+// goroutine safe
+func LoopStats() []LoopStat {
+	return std.LoopStats()
+}
+
+func MetricsHandler() http.Handler {
+	return std.MetricsHandler()
+}
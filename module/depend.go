@@ -0,0 +1,71 @@
+package module
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//模块可以选择实现的接口，声明依赖的模块名
+//动态模式下被依赖的模块先启动、后销毁
+type Dependent interface {
+	DependsOn() []string
+}
+
+func dependsOn(mi Module) []string {
+	if d, ok := mi.(Dependent); ok {
+		return d.DependsOn()
+	}
+	return nil
+}
+
+//按依赖关系排序，被依赖的在前；依赖缺失或者有环时返回错误
+func sortModules(mis map[string]Module) ([]string, error) {
+	names := make([]string, 0, len(mis))
+	for name := range mis {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	inDegree := make(map[string]int, len(mis))
+	dependents := make(map[string][]string, len(mis))
+	for _, name := range names {
+		for _, dep := range dependsOn(mis[name]) {
+			if _, ok := mis[dep]; !ok {
+				return nil, fmt.Errorf("module %s depends on missing module %s", name, dep)
+			}
+			inDegree[name]++
+			dependents[dep] = append(dependents[dep], name)
+		}
+	}
+
+	order := make([]string, 0, len(mis))
+	var ready []string
+	for _, name := range names {
+		if inDegree[name] == 0 {
+			ready = append(ready, name)
+		}
+	}
+	for len(ready) > 0 {
+		name := ready[0]
+		ready = ready[1:]
+		order = append(order, name)
+		for _, d := range dependents[name] {
+			inDegree[d]--
+			if inDegree[d] == 0 {
+				ready = append(ready, d)
+			}
+		}
+	}
+
+	if len(order) != len(mis) {
+		var cycle []string
+		for _, name := range names {
+			if inDegree[name] > 0 {
+				cycle = append(cycle, name)
+			}
+		}
+		return nil, fmt.Errorf("dependency cycle among modules: %s", strings.Join(cycle, ", "))
+	}
+	return order, nil
+}
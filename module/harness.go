@@ -0,0 +1,89 @@
+package module
+
+import (
+	"time"
+
+	"github.com/YiuTerran/leaf/chanrpc"
+	"github.com/YiuTerran/leaf/timer"
+)
+
+//不启动Run，在调用者的goroutine中逐步驱动Skeleton，用于测试
+//Skeleton的Clock需要在Init之前设置为clock，clock为nil时不能调用Advance
+type Harness struct {
+	s     *Skeleton
+	clock *timer.ManualClock
+}
+
+//s需要已经Init，注册的命令和订阅立即生效
+func NewHarness(s *Skeleton, clock *timer.ManualClock) *Harness {
+	h := new(Harness)
+	h.s = s
+	h.clock = clock
+	s.start()
+	s.server.Serve()
+	s.commandServer.Serve()
+	return h
+}
+
+//处理一个等待中的事件，高优先级的调用优先，没有事件时返回false
+func (h *Harness) Step() bool {
+	s := h.s
+	select {
+	case ci := <-s.server.Lane(chanrpc.PriorityUrgent):
+		s.execCall(ci)
+		return true
+	default:
+	}
+	select {
+	case ci := <-s.server.Lane(chanrpc.PriorityHigh):
+		s.execCall(ci)
+		return true
+	default:
+	}
+	select {
+	case ri := <-s.client.ChanAsyncRet:
+		s.execAsyncRet(ri)
+	case ci := <-s.server.ChanCall:
+		s.execCall(ci)
+	case ci := <-s.commandServer.ChanCall:
+		s.execCommand(ci)
+	case cb := <-s.g.ChanCb:
+		s.execCb(cb)
+	case t := <-s.dispatcher.ChanTimer:
+		s.execTimer(t)
+	default:
+		return false
+	}
+	return true
+}
+
+//处理所有等待中的事件，返回处理的数量
+func (h *Harness) RunPending() int {
+	n := 0
+	for h.Step() {
+		n++
+	}
+	return n
+}
+
+//推进时钟，每个定时器触发的时刻都处理完所有等待中的事件，返回处理的数量
+//因此同一时刻触发的定时器不能超过TimerDispatcherLen
+func (h *Harness) Advance(d time.Duration) int {
+	target := h.clock.Now().Add(d)
+	n := h.RunPending()
+	for {
+		next, ok := h.clock.Next()
+		if !ok || next.After(target) {
+			break
+		}
+		h.clock.Set(next)
+		n += h.RunPending()
+	}
+	h.clock.Set(target)
+	return n + h.RunPending()
+}
+
+//执行Run退出时的清理，之后不能再使用
+func (h *Harness) Close() {
+	h.s.close()
+}
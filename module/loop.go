@@ -0,0 +1,234 @@
+package module
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/YiuTerran/leaf/chanrpc"
+)
+
+//Skeleton事件循环的分支
+const (
+	branchCall = iota
+	branchCommand
+	branchAsyncRet
+	branchCb
+	branchTimer
+	numBranch
+)
+
+var branchNames = [numBranch]string{"call", "command", "asyncret", "cb", "timer"}
+
+//检测事件循环延迟的间隔
+const lagInterval = time.Second
+
+type BranchStat struct {
+	Name  string
+	Count int64
+	Total time.Duration
+	Max   time.Duration
+}
+
+func (b BranchStat) Avg() time.Duration {
+	if b.Count == 0 {
+		return 0
+	}
+	return b.Total / time.Duration(b.Count)
+}
+
+type LoopStat struct {
+	Name         string
+	Lag          time.Duration //最近一次检测到的延迟，即定时检测事件从触发到被处理的时间
+	MaxLag       time.Duration
+	Slow         int64 //超过SlowThreshold的回调次数
+	Branches     []BranchStat
+	ChanCall     int //包括所有优先级
+	ChanCb       int
+	ChanTimer    int
+	ChanAsyncRet int
+}
+
+func (s LoopStat) String() string {
+	output := fmt.Sprintf("lag: %v, max lag: %v, slow: %v, queue - call: %v, cb: %v, timer: %v, asyncret: %v",
+		s.Lag, s.MaxLag, s.Slow, s.ChanCall, s.ChanCb, s.ChanTimer, s.ChanAsyncRet)
+	for _, b := range s.Branches {
+		output += fmt.Sprintf("\r\n  %v - count: %v, total: %v, avg: %v, max: %v",
+			b.Name, b.Count, b.Total, b.Avg(), b.Max)
+	}
+	return output
+}
+
+type loopMonitor struct {
+	mutex    sync.Mutex
+	branches [numBranch]BranchStat
+	lag      time.Duration
+	maxLag   time.Duration
+	slow     int64
+}
+
+func (m *loopMonitor) observeLag(lag time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.lag = lag
+	if lag > m.maxLag {
+		m.maxLag = lag
+	}
+}
+
+func (s *Skeleton) observe(branch int, start time.Time, what interface{}) {
+	cost := time.Since(start)
+	m := &s.monitor
+	m.mutex.Lock()
+	b := &m.branches[branch]
+	b.Count++
+	b.Total += cost
+	if cost > b.Max {
+		b.Max = cost
+	}
+	slow := s.SlowThreshold > 0 && cost > s.SlowThreshold
+	if slow {
+		m.slow++
+	}
+	m.mutex.Unlock()
+	if slow {
+		s.manager().log().Warn("module %v: slow %v %v, cost %v", s.Name, branchNames[branch], describe(what), cost)
+	}
+}
+
+//慢回调的名字，chanrpc调用为函数id，其他为函数名
+func describe(what interface{}) string {
+	switch what := what.(type) {
+	case *chanrpc.CallInfo:
+		return fmt.Sprintf("function id %v", what.ID())
+	case *chanrpc.RetInfo:
+		return fmt.Sprintf("function id %v", what.ID())
+	case func():
+		if what == nil {
+			return "nil"
+		}
+		if f := runtime.FuncForPC(reflect.ValueOf(what).Pointer()); f != nil {
+			return f.Name()
+		}
+	}
+	return "unknown"
+}
+
+// goroutine safe
+func (s *Skeleton) LoopStat() LoopStat {
+	m := &s.monitor
+	m.mutex.Lock()
+	stat := LoopStat{
+		Name:     s.Name,
+		Lag:      m.lag,
+		MaxLag:   m.maxLag,
+		Slow:     m.slow,
+		Branches: append([]BranchStat(nil), m.branches[:]...),
+	}
+	m.mutex.Unlock()
+	for i := range stat.Branches {
+		stat.Branches[i].Name = branchNames[i]
+	}
+	for p := chanrpc.PriorityNormal; p <= chanrpc.PriorityUrgent; p++ {
+		stat.ChanCall += len(s.server.Lane(p))
+	}
+	stat.ChanCb = len(s.g.ChanCb)
+	stat.ChanTimer = len(s.dispatcher.ChanTimer)
+	stat.ChanAsyncRet = len(s.client.ChanAsyncRet)
+	return stat
+}
+
+func (mgr *Manager) registerSkeleton(s *Skeleton) {
+	mgr.mutexSkeletons.Lock()
+	defer mgr.mutexSkeletons.Unlock()
+	mgr.skeletons[s.Name] = s
+}
+
+func (mgr *Manager) unregisterSkeleton(s *Skeleton) {
+	mgr.mutexSkeletons.Lock()
+	defer mgr.mutexSkeletons.Unlock()
+	if mgr.skeletons[s.Name] == s {
+		delete(mgr.skeletons, s.Name)
+	}
+}
+
+//本管理器中所有正在运行且有名字的Skeleton的事件循环统计，按名字排序
+// goroutine safe
+func (mgr *Manager) LoopStats() []LoopStat {
+	mgr.mutexSkeletons.RLock()
+	ss := make([]*Skeleton, 0, len(mgr.skeletons))
+	for _, s := range mgr.skeletons {
+		ss = append(ss, s)
+	}
+	mgr.mutexSkeletons.RUnlock()
+	stats := make([]LoopStat, 0, len(ss))
+	for _, s := range ss {
+		stats = append(stats, s.LoopStat())
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Name < stats[j].Name
+	})
+	return stats
+}
+
+func (mgr *Manager) commandLoopStat(args []string) string {
+	var lines []string
+	for _, stat := range mgr.LoopStats() {
+		if len(args) > 0 && stat.Name != args[0] {
+			continue
+		}
+		lines = append(lines, stat.Name+" "+stat.String())
+	}
+	if len(lines) == 0 {
+		return "no skeleton"
+	}
+	return strings.Join(lines, "\r\n")
+}
+
+//以Prometheus文本格式输出LoopStats，可以挂载到任意http服务上
+func (mgr *Manager) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		stats := mgr.LoopStats()
+		metric := func(name string, help string, typ string, f func(stat LoopStat)) {
+			fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+			for _, stat := range stats {
+				f(stat)
+			}
+		}
+		metric("leaf_loop_lag_seconds", "Latest event loop lag.", "gauge", func(stat LoopStat) {
+			fmt.Fprintf(w, "leaf_loop_lag_seconds{module=%q} %v\n", stat.Name, stat.Lag.Seconds())
+		})
+		metric("leaf_loop_slow_total", "Callbacks slower than the threshold.", "counter", func(stat LoopStat) {
+			fmt.Fprintf(w, "leaf_loop_slow_total{module=%q} %v\n", stat.Name, stat.Slow)
+		})
+		metric("leaf_loop_queue_length", "Items waiting in the event loop channels.", "gauge", func(stat LoopStat) {
+			for _, q := range []struct {
+				name string
+				n    int
+			}{{"call", stat.ChanCall}, {"cb", stat.ChanCb}, {"timer", stat.ChanTimer}, {"asyncret", stat.ChanAsyncRet}} {
+				fmt.Fprintf(w, "leaf_loop_queue_length{module=%q,queue=%q} %v\n", stat.Name, q.name, q.n)
+			}
+		})
+		metric("leaf_loop_branch_total", "Events processed by each branch.", "counter", func(stat LoopStat) {
+			for _, b := range stat.Branches {
+				fmt.Fprintf(w, "leaf_loop_branch_total{module=%q,branch=%q} %v\n", stat.Name, b.Name, b.Count)
+			}
+		})
+		metric("leaf_loop_branch_seconds_total", "Time spent in each branch.", "counter", func(stat LoopStat) {
+			for _, b := range stat.Branches {
+				fmt.Fprintf(w, "leaf_loop_branch_seconds_total{module=%q,branch=%q} %v\n", stat.Name, b.Name, b.Total.Seconds())
+			}
+		})
+		metric("leaf_loop_branch_max_seconds", "Longest event of each branch.", "gauge", func(stat LoopStat) {
+			for _, b := range stat.Branches {
+				fmt.Fprintf(w, "leaf_loop_branch_max_seconds{module=%q,branch=%q} %v\n", stat.Name, b.Name, b.Max.Seconds())
+			}
+		})
+	})
+}
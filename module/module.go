@@ -3,15 +3,20 @@ package module
 import (
 	"fmt"
 	"runtime"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/YiuTerran/leaf/chanrpc"
+	"github.com/YiuTerran/leaf/console"
+	"github.com/YiuTerran/leaf/event"
 	"github.com/YiuTerran/leaf/log"
+	"go.uber.org/atomic"
 )
 
 //leaf的模块
 
-//当程序调用Reload时，leaf重新获取当前需要激活的mod，根据Action执行对应的操作
+// 当程序调用Reload时，leaf重新获取当前需要激活的mod，根据Action执行对应的操作
 type Action int
 
 const (
@@ -19,6 +24,7 @@ const (
 	New
 	Update
 	Delete
+	Replace //先启动新实例再销毁旧实例，新实例OnInit发生panic时保留旧实例
 )
 
 type Module interface {
@@ -31,103 +37,463 @@ type Module interface {
 }
 
 type module struct {
+	mgr      *Manager
 	mi       Module
 	closeSig chan struct{}
+	stopping chan struct{} //开始销毁时关闭，停止重启
 	wg       sync.WaitGroup
+	sup      supervisor
+	state    atomic.Int32
+	started  time.Time
 }
 
-var (
-	mods       = make(map[string]*module)
+func newModule(mgr *Manager, mi Module) *module {
+	m := new(module)
+	m.mgr = mgr
+	m.mi = mi
+	m.closeSig = make(chan struct{}, 1)
+	m.stopping = make(chan struct{})
+	m.started = time.Now()
+	return m
+}
+
+// 模块管理器，持有一组模块，包级函数操作默认的管理器
+type Manager struct {
+	mods       map[string]*module
 	lock       sync.Mutex
 	staticMode bool //静态模式
-)
 
-func Reload(actionMds map[Action][]Module) {
-	lock.Lock()
-	defer lock.Unlock()
-	if staticMode {
-		return
+	routes      map[string]*module //按名字查找模块，替换模块时原子切换，不受Reload阻塞
+	mutexRoutes sync.RWMutex
+
+	moduleTimeout time.Duration //每个模块的默认超时
+	totalTimeout  time.Duration //Destroy的总超时
+
+	escalate      func(name string)
+	mutexEscalate sync.Mutex
+
+	//模块的Skeleton默认使用的console和事件总线，以及有名字的Skeleton
+	console        *console.Console
+	bus            *event.Bus
+	skeletons      map[string]*Skeleton
+	mutexSkeletons sync.RWMutex
+	logger         atomic.Value
+}
+
+//创建一个使用独立console和事件总线的管理器
+func NewManager() *Manager {
+	return newManager(console.New(), event.NewBus())
+}
+
+func newManager(con *console.Console, bus *event.Bus) *Manager {
+	mgr := new(Manager)
+	mgr.mods = make(map[string]*module)
+	mgr.routes = make(map[string]*module)
+	mgr.console = con
+	mgr.bus = bus
+	mgr.skeletons = make(map[string]*Skeleton)
+	return mgr
+}
+
+//默认的管理器使用console和event包的默认实例
+var std = newManager(console.Default(), event.Default())
+
+// 包级函数使用的管理器
+func Default() *Manager {
+	return std
+}
+
+//模块的Skeleton没有设置Console时注册命令的console
+func (mgr *Manager) Console() *console.Console {
+	return mgr.console
+}
+
+//模块的Skeleton订阅和取消订阅使用的事件总线
+func (mgr *Manager) Bus() *event.Bus {
+	return mgr.bus
+}
+
+//管理模块时输出日志使用的Logger，默认使用log包的默认实例
+func (mgr *Manager) SetLogger(l *log.Logger) {
+	mgr.logger.Store(l)
+}
+
+func (mgr *Manager) log() *log.Logger {
+	if l, ok := mgr.logger.Load().(*log.Logger); ok && l != nil {
+		return l
 	}
+	return log.Default()
+}
+
+func (mgr *Manager) route(m *module) {
+	mgr.mutexRoutes.Lock()
+	defer mgr.mutexRoutes.Unlock()
+	mgr.routes[m.mi.Name()] = m
+}
+
+func (mgr *Manager) unroute(m *module) {
+	mgr.mutexRoutes.Lock()
+	defer mgr.mutexRoutes.Unlock()
+	if mgr.routes[m.mi.Name()] == m {
+		delete(mgr.routes, m.mi.Name())
+	}
+}
+
+// 按依赖顺序先销毁需要删除和更新的模块，再启动新增和更新的模块，替换的模块在启动时依次切换
+// 依赖缺失或者有环时不做任何修改，返回错误
+func (mgr *Manager) Reload(actionMds map[Action][]Module) error {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+	if mgr.staticMode {
+		return nil
+	}
+	//除了替换，不管是哪种行为，都要删除旧模块
+	stop := make(map[string]struct{})
+	start := make(map[string]Module)
+	replace := make(map[string]Module)
 	for action, mis := range actionMds {
-		//不管是哪种行为，都要删除旧模块
 		for _, mi := range mis {
-			if old, ok := mods[mi.Name()]; !ok {
+			action := action
+			old, ok := mgr.mods[mi.Name()]
+			if action == Replace {
+				if ok && canReplace(old, mi) {
+					replace[mi.Name()] = mi
+					continue
+				}
+				action = Update
+				if !ok {
+					action = New
+				}
+			}
+			if !ok {
 				if action != New {
-					log.Info("no active module %s, ignore", mi.Name())
+					mgr.log().Info("no active module %s, ignore", mi.Name())
 				}
 			} else {
-				destroyMod(old)
+				stop[mi.Name()] = struct{}{}
 				if action == New {
-					log.Warn("register new module but old exists, destroy module %s", mi.Name())
+					mgr.log().Warn("register new module but old exists, destroy module %s", mi.Name())
 				}
 			}
+			//新增模块
+			if action == New || action == Update {
+				start[mi.Name()] = mi
+			}
+		}
+	}
+
+	//检查重载之后的依赖关系
+	final := make(map[string]Module)
+	for name, m := range mgr.mods {
+		if _, ok := stop[name]; !ok {
+			final[name] = m.mi
+		}
+	}
+	for name, mi := range start {
+		final[name] = mi
+	}
+	for name, mi := range replace {
+		final[name] = mi
+	}
+	startOrder, err := sortModules(final)
+	if err != nil {
+		mgr.log().Error("reload modules failed: %v", err)
+		return err
+	}
+
+	running := make(map[string]Module, len(mgr.mods))
+	for name, m := range mgr.mods {
+		running[name] = m.mi
+	}
+	stopOrder, err := sortModules(running)
+	if err != nil {
+		//正常情况下不会出现
+		stopOrder = stopOrder[:0]
+		for name := range running {
+			stopOrder = append(stopOrder, name)
+		}
+	}
+	for i := len(stopOrder) - 1; i >= 0; i-- {
+		if _, ok := stop[stopOrder[i]]; ok {
+			mod := mgr.mods[stopOrder[i]]
+			mgr.destroyMod(stopOrder[i], mod, mgr.shutdownTimeout(mod, time.Time{}))
 		}
-		//新增模块
-		if action == New || action == Update {
-			for _, mi := range mis {
-				m := new(module)
-				m.mi = mi
-				m.closeSig = make(chan struct{}, 1)
-				mods[mi.Name()] = m
-				mi.OnInit()
-				m.wg.Add(1)
-				go run(m)
-				log.Info("module registered: %s", mi.Name())
+	}
+
+	var replaceErr error
+	for _, name := range startOrder {
+		if mi, ok := replace[name]; ok {
+			if err := mgr.replaceMod(mgr.mods[name], mi); err != nil && replaceErr == nil {
+				replaceErr = err
 			}
+			continue
+		}
+		mi, ok := start[name]
+		if !ok {
+			continue
 		}
+		m := newModule(mgr, mi)
+		mgr.mods[mi.Name()] = m
+		mi.OnInit()
+		mgr.bind(mi)
+		mgr.route(m)
+		m.wg.Add(1)
+		go run(m)
+		mgr.log().Info("module registered: %s", mi.Name())
+	}
+	return replaceErr
+}
+
+//嵌入Skeleton的模块，OnInit之后由管理器绑定，见Skeleton.bind
+type binder interface {
+	bind(mgr *Manager)
+}
+
+func (mgr *Manager) bind(mi Module) {
+	if b, ok := mi.(binder); ok {
+		b.bind(mgr)
 	}
 }
 
-//静态加载，按严格的顺序加载模块
-func StaticLoad(mis []Module) {
-	lock.Lock()
-	defer lock.Unlock()
-	staticMode = true
+// 新旧实例必须是不同的对象，并且不能共用同一个RPCServer，否则只能先销毁再启动
+func canReplace(old *module, mi Module) bool {
+	mgr := old.mgr
+	if old.mi == mi {
+		mgr.log().Warn("module %s replaced by the same instance, update instead", mi.Name())
+		return false
+	}
+	if s := mi.RPCServer(); s != nil && s == old.mi.RPCServer() {
+		mgr.log().Warn("module %s shares RPCServer with the old instance, update instead", mi.Name())
+		return false
+	}
+	return true
+}
+
+func (mgr *Manager) initModule(mi Module) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			buf := make([]byte, log.LenStackBuf)
+			l := runtime.Stack(buf, false)
+			mgr.log().Error("panic when init module %s, %v: %s", mi.Name(), r, buf[:l])
+			err = fmt.Errorf("init module %s: %v", mi.Name(), r)
+		}
+	}()
+	mi.OnInit()
+	return
+}
+
+// 启动新实例，切换路由，等旧实例处理完已经收到的调用后再销毁
+func (mgr *Manager) replaceMod(old *module, mi Module) error {
+	if err := mgr.initModule(mi); err != nil {
+		mgr.log().Error("replace module %s failed, keep the old instance", mi.Name())
+		return err
+	}
+	mgr.bind(mi)
+	m := newModule(mgr, mi)
+	mgr.mods[mi.Name()] = m
+	mgr.route(m)
+	m.wg.Add(1)
+	go run(m)
+	mgr.log().Info("module replaced: %s", mi.Name())
+
+	timeout := mgr.shutdownTimeout(old, time.Time{})
+	drain(old, timeout)
+	mgr.destroyMod(mi.Name(), old, timeout)
+	return nil
+}
+
+// 等待RPCServer中排队的调用处理完，timeout为0时一直等待
+func drain(m *module, timeout time.Duration) {
+	s := m.mi.RPCServer()
+	if s == nil {
+		return
+	}
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	for s.Stat().QueueLen > 0 {
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			m.mgr.log().Warn("module %s not drained in %v", m.mi.Name(), timeout)
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// 对比期望的模块列表和正在运行的模块，根据Version得出需要执行的操作
+// 不在列表中的运行模块会被删除
+func (mgr *Manager) Diff(desired []Module) map[Action][]Module {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+	actions := make(map[Action][]Module)
+	if mgr.staticMode {
+		return actions
+	}
+	names := make(map[string]struct{}, len(desired))
+	for _, mi := range desired {
+		names[mi.Name()] = struct{}{}
+		if old, ok := mgr.mods[mi.Name()]; !ok {
+			actions[New] = append(actions[New], mi)
+		} else if old.mi.Version() != mi.Version() {
+			actions[Update] = append(actions[Update], mi)
+		}
+	}
+	for name, m := range mgr.mods {
+		if _, ok := names[name]; !ok {
+			actions[Delete] = append(actions[Delete], m.mi)
+		}
+	}
+	return actions
+}
+
+// 按期望的模块列表重新加载
+func (mgr *Manager) Sync(desired []Module) error {
+	return mgr.Reload(mgr.Diff(desired))
+}
+
+// 静态加载，按严格的顺序加载模块
+func (mgr *Manager) StaticLoad(mis []Module) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+	mgr.staticMode = true
 	for i, mi := range mis {
-		m := new(module)
-		m.mi = mi
-		m.closeSig = make(chan struct{}, 1)
-		mods[fmt.Sprint(i)] = m
+		m := newModule(mgr, mi)
+		mgr.mods[fmt.Sprint(i)] = m
 		mi.OnInit()
+		mgr.bind(mi)
+		mgr.route(m)
 		m.wg.Add(1)
 		go run(m)
-		log.Info("module registered: %s", mi.Name())
+		mgr.log().Info("module registered: %s", mi.Name())
+	}
+}
+
+// 模块可以选择实现的接口，指定销毁时等待Run退出的最长时间，优先于SetShutdownTimeout
+type GracefulTimeout interface {
+	ShutdownTimeout() time.Duration
+}
+
+// 设置销毁模块的超时，0表示一直等待
+// 超时的模块不再调用OnDestroy，Destroy会返回这些模块
+func (mgr *Manager) SetShutdownTimeout(perModule time.Duration, total time.Duration) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+	mgr.moduleTimeout = perModule
+	mgr.totalTimeout = total
+}
+
+func (mgr *Manager) shutdownTimeout(mod *module, deadline time.Time) time.Duration {
+	timeout := mgr.moduleTimeout
+	if t, ok := mod.mi.(GracefulTimeout); ok {
+		timeout = t.ShutdownTimeout()
+	}
+	if !deadline.IsZero() {
+		left := time.Until(deadline)
+		if left <= 0 {
+			left = time.Nanosecond
+		}
+		if timeout <= 0 || left < timeout {
+			timeout = left
+		}
 	}
+	return timeout
 }
 
-func destroyMod(mod *module) {
+// 返回模块是否在超时之前退出
+func (mgr *Manager) destroyMod(key string, mod *module, timeout time.Duration) (finished bool) {
 	defer func() {
 		if r := recover(); r != nil {
 			buf := make([]byte, log.LenStackBuf)
 			l := runtime.Stack(buf, false)
-			log.Error("panic when destroy module %s, %v: %s", mod.mi.Name(), r, buf[:l])
+			mgr.log().Error("panic when destroy module %s, %v: %s", mod.mi.Name(), r, buf[:l])
 		}
 	}()
+	close(mod.stopping)
+	mod.state.Store(int32(StateStopping))
+	mgr.unroute(mod)
 	mod.closeSig <- struct{}{}
-	mod.wg.Wait()
+	if timeout > 0 {
+		done := make(chan struct{})
+		go func() {
+			mod.wg.Wait()
+			close(done)
+		}()
+		t := time.NewTimer(timeout)
+		select {
+		case <-done:
+			t.Stop()
+		case <-t.C:
+			mgr.forget(key, mod)
+			mgr.log().Error("module %s did not stop in %v", mod.mi.Name(), timeout)
+			return false
+		}
+	} else {
+		mod.wg.Wait()
+	}
+	finished = true
 	mod.mi.OnDestroy()
-	delete(mods, mod.mi.Name())
-	log.Info("module destroyed: %s", mod.mi.Name())
+	mgr.forget(key, mod)
+	mgr.log().Info("module destroyed: %s", mod.mi.Name())
+	return
 }
 
-func Destroy() {
-	lock.Lock()
-	defer lock.Unlock()
+// 替换模块时key可能已经指向新实例
+func (mgr *Manager) forget(key string, mod *module) {
+	if mgr.mods[key] == mod {
+		delete(mgr.mods, key)
+	}
+}
+
+// 返回没有在超时之前退出的模块
+func (mgr *Manager) Destroy() (unfinished []string) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+	var deadline time.Time
+	if mgr.totalTimeout > 0 {
+		deadline = time.Now().Add(mgr.totalTimeout)
+	}
+	defer func() {
+		if len(unfinished) > 0 {
+			mgr.log().Error("modules not stopped in time: %s", strings.Join(unfinished, ", "))
+		}
+	}()
 	//静态模式下按着严格的顺序逆序销毁模块
-	if staticMode {
-		for i := len(mods) - 1; i >= 0; i-- {
-			destroyMod(mods[fmt.Sprint(i)])
+	if mgr.staticMode {
+		for i := len(mgr.mods) - 1; i >= 0; i-- {
+			key := fmt.Sprint(i)
+			mod := mgr.mods[key]
+			if !mgr.destroyMod(key, mod, mgr.shutdownTimeout(mod, deadline)) {
+				unfinished = append(unfinished, mod.mi.Name())
+			}
 		}
+		mgr.staticMode = false
 		return
 	}
-	//动态模式下Destroy变得无序，所以在leaf的Run那里注册一个before close的回调来做所有模块关闭前的处理
-	for _, mod := range mods {
-		log.Debug("destroying module %s", mod.mi.Name())
-		destroyMod(mod)
+	//动态模式下按依赖关系逆序销毁，没有声明依赖的模块之间仍然是无序的，
+	//可以在leaf的Run那里注册一个before close的回调来做所有模块关闭前的处理
+	running := make(map[string]Module, len(mgr.mods))
+	for name, m := range mgr.mods {
+		running[name] = m.mi
+	}
+	order, err := sortModules(running)
+	if err != nil {
+		mgr.log().Error("destroy modules: %v", err)
+		for name := range mgr.mods {
+			order = append(order, name)
+		}
+	}
+	for i := len(order) - 1; i >= 0; i-- {
+		mod := mgr.mods[order[i]]
+		mgr.log().Debug("destroying module %s", mod.mi.Name())
+		if !mgr.destroyMod(order[i], mod, mgr.shutdownTimeout(mod, deadline)) {
+			unfinished = append(unfinished, mod.mi.Name())
+		}
 	}
+	return
 }
 
 func run(m *module) {
-	m.mi.Run(m.closeSig)
-	m.wg.Done()
+	defer m.wg.Done()
+	m.supervise()
 }
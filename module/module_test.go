@@ -0,0 +1,411 @@
+package module
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/YiuTerran/leaf/chanrpc"
+	"github.com/YiuTerran/leaf/log"
+	"github.com/YiuTerran/leaf/timer"
+)
+
+type fakeModule struct {
+	name  string
+	deps  []string
+	trace *[]string
+}
+
+func (m *fakeModule) Name() string               { return m.name }
+func (m *fakeModule) Version() string            { return "1" }
+func (m *fakeModule) OnInit()                    { *m.trace = append(*m.trace, "init "+m.name) }
+func (m *fakeModule) OnDestroy()                 { *m.trace = append(*m.trace, "destroy "+m.name) }
+func (m *fakeModule) Run(closeSig chan struct{}) { <-closeSig }
+func (m *fakeModule) RPCServer() *chanrpc.Server { return nil }
+func (m *fakeModule) DependsOn() []string        { return m.deps }
+
+func TestReloadOrder(t *testing.T) {
+	log.InitLogger("")
+	var trace []string
+	gate := &fakeModule{name: "gate", deps: []string{"game"}, trace: &trace}
+	game := &fakeModule{name: "game", deps: []string{"db"}, trace: &trace}
+	db := &fakeModule{name: "db", trace: &trace}
+
+	if err := Reload(map[Action][]Module{New: {gate, game, db}}); err != nil {
+		t.Fatal(err)
+	}
+	// gate can not outlive game
+	if err := Reload(map[Action][]Module{Delete: {game}}); err == nil {
+		t.Fatal("expected missing dependency error")
+	}
+	db.deps = []string{"gate"}
+	if err := Reload(map[Action][]Module{Update: {db}}); err == nil {
+		t.Fatal("expected dependency cycle error")
+	}
+	db.deps = nil
+	Destroy()
+
+	expected := "init db,init game,init gate,destroy gate,destroy game,destroy db"
+	if strings.Join(trace, ",") != expected {
+		t.Fatalf("unexpected order %v", trace)
+	}
+}
+
+type panicModule struct {
+	fakeModule
+	runs   int
+	policy RestartPolicy
+}
+
+func (m *panicModule) Run(closeSig chan struct{}) {
+	m.runs++
+	if m.runs <= 3 {
+		panic("oops")
+	}
+	<-closeSig
+}
+
+func (m *panicModule) RestartPolicy() RestartPolicy {
+	return m.policy
+}
+
+func TestSupervise(t *testing.T) {
+	log.InitLogger("")
+	var trace []string
+	escalated := make(chan string, 1)
+	SetEscalation(func(name string) {
+		escalated <- name
+	})
+	defer SetEscalation(nil)
+
+	always := &panicModule{fakeModule: fakeModule{name: "always", trace: &trace},
+		policy: RestartPolicy{Mode: RestartBackoff, Backoff: time.Millisecond}}
+	limited := &panicModule{fakeModule: fakeModule{name: "limited", trace: &trace},
+		policy: RestartPolicy{Mode: RestartAlways, MaxRestarts: 1, Window: time.Minute, Escalate: true}}
+	unlimited := &panicModule{fakeModule: fakeModule{name: "unlimited", trace: &trace},
+		policy: RestartPolicy{Mode: RestartAlways}}
+	if err := Reload(map[Action][]Module{New: {always, limited, unlimited}}); err != nil {
+		t.Fatal(err)
+	}
+	//RestartAlways也要等待，不能立即重启
+	time.Sleep(minRestartDelay / 2)
+	if n := Restarts("unlimited"); n != 0 {
+		t.Fatalf("restarted too fast: %v", n)
+	}
+
+	if name := <-escalated; name != "limited" {
+		t.Fatalf("unexpected escalation of %v", name)
+	}
+	for Restarts("always") < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	if n := Restarts("limited"); n != 1 {
+		t.Fatalf("expected 1 restart, got %v", n)
+	}
+	for Restarts("unlimited") < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	// a stopped module must not block destroy
+	Destroy()
+}
+
+type stuckModule struct {
+	fakeModule
+}
+
+func (m *stuckModule) Run(closeSig chan struct{}) {
+	select {}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	log.InitLogger("")
+	SetShutdownTimeout(10*time.Millisecond, time.Second)
+	defer SetShutdownTimeout(0, 0)
+
+	var trace []string
+	stuck := &stuckModule{fakeModule{name: "stuck", trace: &trace}}
+	normal := &fakeModule{name: "normal", trace: &trace}
+	if err := Reload(map[Action][]Module{New: {stuck, normal}}); err != nil {
+		t.Fatal(err)
+	}
+	unfinished := Destroy()
+	if len(unfinished) != 1 || unfinished[0] != "stuck" {
+		t.Fatalf("unexpected unfinished modules %v", unfinished)
+	}
+	expected := "init normal,init stuck,destroy normal"
+	if strings.Join(trace, ",") != expected {
+		t.Fatalf("unexpected trace %v", trace)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	log.InitLogger("")
+	var trace []string
+	game := &fakeModule{name: "game", trace: &trace}
+	gate := &fakeModule{name: "gate", deps: []string{"game"}, trace: &trace}
+	if err := Reload(map[Action][]Module{New: {gate, game}}); err != nil {
+		t.Fatal(err)
+	}
+	defer Destroy()
+
+	infos := List()
+	if len(infos) != 2 || infos[0].Name != "game" || infos[1].State != StateRunning {
+		t.Fatalf("unexpected infos %v", infos)
+	}
+	if info, ok := Lookup("gate"); !ok || info.DependsOn[0] != "game" {
+		t.Fatalf("unexpected info %v", info)
+	}
+	if _, ok := Lookup("db"); ok {
+		t.Fatal("unexpected module db")
+	}
+
+	if err := ReloadModule("gate"); err != nil {
+		t.Fatal(err)
+	}
+	if ReloadModule("db") == nil {
+		t.Fatal("expected no active module error")
+	}
+	expected := "init game,init gate,destroy gate,init gate"
+	if strings.Join(trace, ",") != expected {
+		t.Fatalf("unexpected trace %v", trace)
+	}
+}
+
+type skeletonModule struct {
+	*Skeleton
+	inits   int
+	running []bool //OnInit时Skeleton是否在运行
+}
+
+func (m *skeletonModule) Name() string               { return "skeleton" }
+func (m *skeletonModule) Version() string            { return "1" }
+func (m *skeletonModule) OnDestroy()                 {}
+func (m *skeletonModule) RPCServer() *chanrpc.Server { return m.ChanRPCServer }
+
+func (m *skeletonModule) OnInit() {
+	m.inits++
+	m.running = append(m.running, m.Skeleton.running)
+	m.RegisterChanRPC("inits", func([]interface{}) interface{} {
+		return m.inits
+	})
+}
+
+func TestReloadSkeleton(t *testing.T) {
+	log.InitLogger("")
+	//同一个Skeleton在多次OnInit之间复用，和包级变量的用法一样
+	s := &Skeleton{GoLen: 10, TimerDispatcherLen: 10, AsyncCallLen: 10, ChanRPCServer: chanrpc.NewServer(10)}
+	s.Init()
+	m := &skeletonModule{Skeleton: s}
+	var trace []string
+	other := &replaceModule{fakeModule{name: "other", trace: &trace}, chanrpc.NewServer(1), false}
+	if err := Reload(map[Action][]Module{New: {m, other}}); err != nil {
+		t.Fatal(err)
+	}
+	defer Destroy()
+
+	if ret, err := s.ChanRPCServer.Call1("inits"); err != nil || ret != 1 {
+		t.Fatalf("unexpected result %v, %v", ret, err)
+	}
+	if err := ReloadModule("skeleton"); err != nil {
+		t.Fatal(err)
+	}
+	if ret, err := s.ChanRPCServer.Call1("inits"); err != nil || ret != 2 {
+		t.Fatalf("unexpected result after reload %v, %v", ret, err)
+	}
+	if info, _ := Lookup("skeleton"); info.State != StateRunning || info.Restarts != 0 {
+		t.Fatalf("unexpected info %v", info)
+	}
+	//OnInit中注册的命令和订阅要等到Run才生效
+	if len(m.running) != 2 || m.running[0] || m.running[1] {
+		t.Fatalf("unexpected running state in OnInit %v", m.running)
+	}
+	//没有Skeleton的模块不能重新打开关闭的RPCServer
+	if err := ReloadModule("other"); err == nil {
+		t.Fatal("expected reload error")
+	}
+}
+
+type versionModule struct {
+	fakeModule
+	version string
+}
+
+func (m *versionModule) Version() string { return m.version }
+
+func TestDiff(t *testing.T) {
+	log.InitLogger("")
+	var trace []string
+	game := &versionModule{fakeModule{name: "game", trace: &trace}, "1"}
+	gate := &versionModule{fakeModule{name: "gate", trace: &trace}, "1"}
+	if err := Sync([]Module{game, gate}); err != nil {
+		t.Fatal(err)
+	}
+	defer Destroy()
+
+	newGame := &versionModule{fakeModule{name: "game", trace: &trace}, "2"}
+	db := &versionModule{fakeModule{name: "db", trace: &trace}, "1"}
+	actions := Diff([]Module{newGame, db})
+	if len(actions[New]) != 1 || actions[New][0] != db ||
+		len(actions[Update]) != 1 || actions[Update][0] != newGame ||
+		len(actions[Delete]) != 1 || actions[Delete][0].Name() != "gate" {
+		t.Fatalf("unexpected actions %v", actions)
+	}
+	if err := Reload(actions); err != nil {
+		t.Fatal(err)
+	}
+	if actions := Diff([]Module{newGame, db}); len(actions) != 0 {
+		t.Fatalf("unexpected actions %v", actions)
+	}
+}
+
+type replaceModule struct {
+	fakeModule
+	server  *chanrpc.Server
+	initErr bool
+}
+
+func (m *replaceModule) OnInit() {
+	if m.initErr {
+		panic("init failed")
+	}
+	m.fakeModule.OnInit()
+}
+
+func (m *replaceModule) RPCServer() *chanrpc.Server { return m.server }
+
+func TestReplace(t *testing.T) {
+	log.InitLogger("")
+	var trace []string
+	blue := &replaceModule{fakeModule{name: "game", trace: &trace}, chanrpc.NewServer(1), false}
+	if err := Reload(map[Action][]Module{New: {blue}}); err != nil {
+		t.Fatal(err)
+	}
+	defer Destroy()
+
+	broken := &replaceModule{fakeModule{name: "game", trace: &trace}, chanrpc.NewServer(1), true}
+	if err := Reload(map[Action][]Module{Replace: {broken}}); err == nil {
+		t.Fatal("expected init error")
+	}
+	if RPCServer("game") != blue.server {
+		t.Fatal("expected the old instance after rollback")
+	}
+
+	green := &replaceModule{fakeModule{name: "game", trace: &trace}, chanrpc.NewServer(1), false}
+	if err := Reload(map[Action][]Module{Replace: {green}}); err != nil {
+		t.Fatal(err)
+	}
+	if RPCServer("game") != green.server {
+		t.Fatal("expected the new instance")
+	}
+	if info, ok := Lookup("game"); !ok || info.State != StateRunning {
+		t.Fatalf("unexpected info %v", info)
+	}
+	expected := "init game,init game,destroy game"
+	if strings.Join(trace, ",") != expected {
+		t.Fatalf("unexpected trace %v", trace)
+	}
+}
+
+func TestLoopStat(t *testing.T) {
+	log.InitLogger("")
+	s := &Skeleton{Name: "loop", GoLen: 10, TimerDispatcherLen: 10, AsyncCallLen: 10,
+		ChanRPCServer: chanrpc.NewServer(10), SlowThreshold: time.Millisecond}
+	s.Init()
+	s.RegisterChanRPC("slow", func([]interface{}) {
+		time.Sleep(5 * time.Millisecond)
+	})
+	closeSig := make(chan struct{}, 1)
+	done := make(chan struct{})
+	go func() {
+		s.Run(closeSig)
+		close(done)
+	}()
+	defer func() {
+		closeSig <- struct{}{}
+		<-done
+	}()
+
+	if err := s.ChanRPCServer.Call0("slow"); err != nil {
+		t.Fatal(err)
+	}
+	//the call returns before the branch is observed
+	for s.LoopStat().Branches[branchCall].Count == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	stats := LoopStats()
+	if len(stats) != 1 || stats[0].Branches[branchCall].Count != 1 || stats[0].Slow != 1 {
+		t.Fatalf("unexpected stats %v", stats)
+	}
+
+	w := httptest.NewRecorder()
+	MetricsHandler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(w.Body.String(), `leaf_loop_branch_total{module="loop",branch="call"} 1`) {
+		t.Fatalf("unexpected metrics %v", w.Body.String())
+	}
+}
+
+func TestDeadlockInCallback(t *testing.T) {
+	log.InitLogger("")
+	chanrpc.EnableDebug(true)
+	defer chanrpc.EnableDebug(false)
+	s := &Skeleton{GoLen: 10, TimerDispatcherLen: 10, AsyncCallLen: 10, ChanRPCServer: chanrpc.NewServer(10)}
+	s.Init()
+	s.RegisterChanRPC("self", func([]interface{}) {})
+	errs := make(chan error, 1)
+	s.AfterFunc(0, func() {
+		//同步调用自己的server，在回调中也要检测到死锁而不是一直等待
+		errs <- s.ChanRPCServer.Call0("self")
+	})
+	closeSig := make(chan struct{}, 1)
+	go s.Run(closeSig)
+	defer func() {
+		closeSig <- struct{}{}
+	}()
+
+	select {
+	case err := <-errs:
+		if err == nil || !strings.Contains(err.Error(), "chanrpc deadlock") {
+			t.Fatalf("expected deadlock error, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("self call in a timer callback hangs")
+	}
+}
+
+func TestHarness(t *testing.T) {
+	log.InitLogger("")
+	clock := timer.NewManualClock(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+	s := &Skeleton{GoLen: 10, TimerDispatcherLen: 10, AsyncCallLen: 10,
+		ChanRPCServer: chanrpc.NewServer(10), Clock: clock}
+	s.Init()
+	h := NewHarness(s, clock)
+	defer h.Close()
+
+	var trace []string
+	s.RegisterChanRPC("buff", func([]interface{}) {
+		trace = append(trace, "buff "+clock.Now().Format("2 15:04"))
+		s.AfterFunc(time.Hour, func() {
+			trace = append(trace, "cooldown "+clock.Now().Format("2 15:04"))
+		})
+	})
+	cronExpr, err := timer.NewCronExpr("0 5 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.CronFunc(cronExpr, func() {
+		trace = append(trace, "reset "+clock.Now().Format("2 15:04"))
+	})
+
+	s.ChanRPCServer.Go("buff")
+	if !h.Step() || h.Step() {
+		t.Fatal("expected exactly one event")
+	}
+	if n := h.Advance(48 * time.Hour); n != 3 {
+		t.Fatalf("unexpected events %v", n)
+	}
+	expected := "buff 1 00:00,cooldown 1 01:00,reset 1 05:00,reset 2 05:00"
+	if strings.Join(trace, ",") != expected {
+		t.Fatalf("unexpected trace %v", trace)
+	}
+}
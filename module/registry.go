@@ -0,0 +1,196 @@
+package module
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/YiuTerran/leaf/chanrpc"
+	"github.com/YiuTerran/leaf/console"
+)
+
+type State int32
+
+const (
+	StateRunning    State = iota
+	StateRestarting       //Run发生panic，等待重启
+	StateStopped          //Run发生panic，不再重启
+	StateStopping         //正在销毁
+)
+
+func (s State) String() string {
+	switch s {
+	case StateRunning:
+		return "running"
+	case StateRestarting:
+		return "restarting"
+	case StateStopped:
+		return "stopped"
+	case StateStopping:
+		return "stopping"
+	}
+	return "unknown"
+}
+
+type Info struct {
+	Name      string
+	Version   string
+	State     State
+	Started   time.Time
+	Uptime    time.Duration
+	Restarts  int
+	DependsOn []string
+}
+
+func (m *module) info() Info {
+	return Info{
+		Name:      m.mi.Name(),
+		Version:   m.mi.Version(),
+		State:     State(m.state.Load()),
+		Started:   m.started,
+		Uptime:    time.Since(m.started),
+		Restarts:  int(m.sup.restarts.Load()),
+		DependsOn: dependsOn(m.mi),
+	}
+}
+
+//静态模式下按加载顺序，动态模式下按名字排序
+// goroutine safe
+func (mgr *Manager) List() []Info {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+	infos := make([]Info, 0, len(mgr.mods))
+	if mgr.staticMode {
+		for i := 0; i < len(mgr.mods); i++ {
+			if m, ok := mgr.mods[fmt.Sprint(i)]; ok {
+				infos = append(infos, m.info())
+			}
+		}
+		return infos
+	}
+	for _, m := range mgr.mods {
+		infos = append(infos, m.info())
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+	return infos
+}
+
+func (mgr *Manager) lookup(name string) *module {
+	for _, m := range mgr.mods {
+		if m.mi.Name() == name {
+			return m
+		}
+	}
+	return nil
+}
+
+// goroutine safe
+func (mgr *Manager) Lookup(name string) (Info, bool) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+	if m := mgr.lookup(name); m != nil {
+		return m.info(), true
+	}
+	return Info{}, false
+}
+
+//模块的RPCServer，模块不存在或者没有时返回nil
+//替换模块时切换到新实例，不会等待Reload完成，调用方不应该长期保存返回值
+// goroutine safe
+func (mgr *Manager) RPCServer(name string) *chanrpc.Server {
+	mgr.mutexRoutes.RLock()
+	defer mgr.mutexRoutes.RUnlock()
+	if m, ok := mgr.routes[name]; ok {
+		return m.mi.RPCServer()
+	}
+	return nil
+}
+
+//模块销毁时会关闭RPCServer，只有嵌入Skeleton的模块可以重新打开
+func canReuse(mi Module) bool {
+	if mi.RPCServer() == nil {
+		return true
+	}
+	_, ok := mi.(binder)
+	return ok
+}
+
+//用同一个实例重启模块：销毁后重新OnInit和Run，静态模式下不支持
+//有RPCServer但没有嵌入Skeleton的模块无法继续使用关闭的server，需要用Replace换成新实例
+func (mgr *Manager) ReloadModule(name string) error {
+	mgr.lock.Lock()
+	if mgr.staticMode {
+		mgr.lock.Unlock()
+		return fmt.Errorf("can not reload module %s in static mode", name)
+	}
+	m := mgr.lookup(name)
+	mgr.lock.Unlock()
+	if m == nil {
+		return fmt.Errorf("no active module %s", name)
+	}
+	if !canReuse(m.mi) {
+		return fmt.Errorf("can not reload module %s in place, replace it with a new instance", name)
+	}
+	return mgr.Reload(map[Action][]Module{Update: {m.mi}})
+}
+
+//在console中注册modules、module和loopstat命令，leaf.App在Run时注册到自己的console
+func (mgr *Manager) RegisterCommands(con *console.Console) {
+	con.RegisterFunc("modules", "list the modules", mgr.commandModules)
+	con.RegisterFunc("module", "show or reload a module, see `module` for usage", mgr.commandModule)
+	con.RegisterFunc("loopstat", "event loop statistics of the named skeletons, see `loopstat name`", mgr.commandLoopStat)
+}
+
+//注销RegisterCommands注册的命令
+func (mgr *Manager) UnregisterCommands(con *console.Console) {
+	con.Unregister("modules")
+	con.Unregister("module")
+	con.Unregister("loopstat")
+}
+
+func (mgr *Manager) commandModules([]string) string {
+	var lines []string
+	for _, info := range mgr.List() {
+		lines = append(lines, fmt.Sprintf("%s - version: %s, state: %v, uptime: %v, restarts: %v",
+			info.Name, info.Version, info.State, info.Uptime.Truncate(time.Second), info.Restarts))
+	}
+	if len(lines) == 0 {
+		return "no module"
+	}
+	return strings.Join(lines, "\r\n")
+}
+
+func (mgr *Manager) commandModule(args []string) string {
+	usage := "Usage: module info|reload name\r\n" +
+		"  info   - show the module\r\n" +
+		"  reload - destroy the module and start it again"
+	if len(args) != 2 {
+		return usage
+	}
+
+	switch args[0] {
+	case "info":
+		info, ok := mgr.Lookup(args[1])
+		if !ok {
+			return "no active module " + args[1]
+		}
+		return "name: " + info.Name + "\r\n" +
+			"version: " + info.Version + "\r\n" +
+			"state: " + info.State.String() + "\r\n" +
+			"started: " + info.Started.Format(time.RFC3339) + "\r\n" +
+			"uptime: " + info.Uptime.Truncate(time.Second).String() + "\r\n" +
+			"restarts: " + strconv.Itoa(info.Restarts) + "\r\n" +
+			"depends on: " + strings.Join(info.DependsOn, ", ")
+	case "reload":
+		if err := mgr.ReloadModule(args[1]); err != nil {
+			return err.Error()
+		}
+		return "module " + args[1] + " reloaded"
+	default:
+		return usage
+	}
+}
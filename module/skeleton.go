@@ -5,20 +5,41 @@ import (
 
 	"github.com/YiuTerran/leaf/chanrpc"
 	"github.com/YiuTerran/leaf/console"
+	"github.com/YiuTerran/leaf/event"
 	"github.com/YiuTerran/leaf/go"
 	"github.com/YiuTerran/leaf/timer"
 )
 
 type Skeleton struct {
-	GoLen              int //回调缓冲区长度限制
-	TimerDispatcherLen int //定时器缓冲区长度限制
-	AsyncCallLen       int //异步调用结果缓冲区长度限制
+	Name               string //非空时在所属的console和管理器中可以查看ChanRPCServer和事件循环的统计
+	GoLen              int    //回调缓冲区长度限制
+	KeyedWorkers       int    //GoKeyed的最大并发数，默认为CPU数
+	TimerDispatcherLen int    //定时器缓冲区长度限制
+	AsyncCallLen       int    //异步调用结果缓冲区长度限制
 	ChanRPCServer      *chanrpc.Server
+	Console            *console.Console //注册命令的console，默认使用所属管理器的console
+	SlowThreshold      time.Duration    //单个回调超过该时间时打印警告，0不检查
+	Clock              timer.Clock      //定时器使用的时钟，需要在Init之前设置，默认为系统时钟
+	Wheel              *timer.Wheel     //非nil时定时器由时间轮触发，忽略Clock，需要在Init之前设置
 	g                  *g.Go
+	keyed              *g.KeyedContext
 	dispatcher         *timer.Dispatcher
 	client             *chanrpc.Client
 	server             *chanrpc.Server
 	commandServer      *chanrpc.Server
+	rpcIDs             []interface{} //模块销毁时注销，以便重新加载的模块再次注册
+	commands           []string
+	running            bool
+	pendingCommands    []command //Run之前注册的命令和订阅，Run开始时才生效
+	pendingTopics      []string
+	monitor            loopMonitor
+	mgr                *Manager //所属的管理器，没有加载到管理器时使用默认的管理器
+}
+
+type command struct {
+	name string
+	help string
+	f    interface{}
 }
 
 func (s *Skeleton) Init() {
@@ -33,7 +54,12 @@ func (s *Skeleton) Init() {
 	}
 
 	s.g = g.New(s.GoLen)
-	s.dispatcher = timer.NewDispatcher(s.TimerDispatcherLen)
+	s.keyed = s.g.NewKeyedContext(s.KeyedWorkers)
+	if s.Wheel != nil {
+		s.dispatcher = timer.NewWheelDispatcher(s.TimerDispatcherLen, s.Wheel)
+	} else {
+		s.dispatcher = timer.NewDispatcherWithClock(s.TimerDispatcherLen, s.Clock)
+	}
 	s.client = chanrpc.NewClient(s.AsyncCallLen)
 	s.server = s.ChanRPCServer
 
@@ -43,28 +69,153 @@ func (s *Skeleton) Init() {
 	s.commandServer = chanrpc.NewServer(0)
 }
 
+//模块OnInit之后由管理器调用：在Run之前决定使用的console、事件总线和注册表，
+//并重新打开上次Run退出时关闭的server，使嵌入Skeleton的模块可以用同一个实例重新加载
+func (s *Skeleton) bind(mgr *Manager) {
+	if s == nil {
+		return
+	}
+	s.mgr = mgr
+	s.reopen()
+}
+
+func (s *Skeleton) reopen() {
+	s.server.Reopen()
+	s.commandServer.Reopen()
+}
+
+func (s *Skeleton) manager() *Manager {
+	if s.mgr == nil {
+		return std
+	}
+	return s.mgr
+}
+
+func (s *Skeleton) console() *console.Console {
+	if s.Console == nil {
+		return s.manager().console
+	}
+	return s.Console
+}
+
+//Run开始时才对外注册，替换模块时新实例OnInit失败不会影响正在运行的旧实例
+func (s *Skeleton) start() {
+	s.reopen()
+	s.running = true
+	for _, c := range s.pendingCommands {
+		s.console().Replace(c.name, c.help, c.f, s.commandServer)
+		s.commands = append(s.commands, c.name)
+	}
+	s.pendingCommands = nil
+	for _, topic := range s.pendingTopics {
+		s.manager().bus.Subscribe(topic, s.server)
+	}
+	s.pendingTopics = nil
+	if s.Name != "" {
+		s.console().Servers().Register(s.Name, s.server)
+		s.manager().registerSkeleton(s)
+	}
+}
+
+//每轮最多优先处理的高优先级调用数，避免饿死其他事件
+const priorityBurst = 16
+
 func (s *Skeleton) Run(closeSig chan struct{}) {
+	s.start()
+	s.server.Serve()
+	s.commandServer.Serve()
+	lag := time.NewTicker(lagInterval)
+	defer lag.Stop()
 	for {
+		s.execPriority()
 		select {
 		case <-closeSig:
-			s.commandServer.Close()
-			s.server.Close()
-			for !s.g.Idle() || !s.client.Idle() {
-				s.g.Close()
-				s.client.Close()
-			}
+			s.close()
 			return
+		case t := <-lag.C:
+			s.monitor.observeLag(time.Since(t))
 		case ri := <-s.client.ChanAsyncRet:
-			s.client.Cb(ri)
+			s.execAsyncRet(ri)
 		case ci := <-s.server.ChanCall:
-			s.server.Exec(ci)
+			s.execCall(ci)
+		case ci := <-s.server.Lane(chanrpc.PriorityHigh):
+			s.execCall(ci)
+		case ci := <-s.server.Lane(chanrpc.PriorityUrgent):
+			s.execCall(ci)
 		case ci := <-s.commandServer.ChanCall:
-			s.commandServer.Exec(ci)
+			s.execCommand(ci)
 		case cb := <-s.g.ChanCb:
-			s.g.Cb(cb)
+			s.execCb(cb)
 		case t := <-s.dispatcher.ChanTimer:
-			t.Cb()
+			s.execTimer(t)
+		}
+	}
+}
+
+func (s *Skeleton) close() {
+	if s.Name != "" {
+		s.console().Servers().UnregisterIf(s.Name, s.server)
+		s.manager().unregisterSkeleton(s)
+	}
+	s.manager().bus.UnsubscribeAll(s.server)
+	s.unregister()
+	s.commandServer.Close()
+	s.server.Close()
+	for !s.g.Idle() || !s.client.Idle() {
+		s.g.Close()
+		s.client.Close()
+	}
+	//重新加载时OnInit注册的命令和订阅要等到下一次Run才生效
+	s.running = false
+}
+
+func (s *Skeleton) execCall(ci *chanrpc.CallInfo) {
+	start := time.Now()
+	s.server.Exec(ci)
+	s.observe(branchCall, start, ci)
+}
+
+func (s *Skeleton) execCommand(ci *chanrpc.CallInfo) {
+	start := time.Now()
+	s.commandServer.Exec(ci)
+	s.observe(branchCommand, start, ci)
+}
+
+func (s *Skeleton) execAsyncRet(ri *chanrpc.RetInfo) {
+	start := time.Now()
+	s.client.Cb(ri)
+	s.observe(branchAsyncRet, start, ri)
+}
+
+func (s *Skeleton) execCb(cb func()) {
+	start := time.Now()
+	s.g.Cb(cb)
+	s.observe(branchCb, start, cb)
+}
+
+func (s *Skeleton) execTimer(t *timer.Timer) {
+	start := time.Now()
+	f := t.Func()
+	t.Cb()
+	s.observe(branchTimer, start, f)
+}
+
+//同chanrpc.Server.ExecPriority，逐个统计耗时
+func (s *Skeleton) execPriority() {
+	for i := 0; i < priorityBurst; i++ {
+		var ci *chanrpc.CallInfo
+		select {
+		case ci = <-s.server.Lane(chanrpc.PriorityUrgent):
+		default:
+			select {
+			case ci = <-s.server.Lane(chanrpc.PriorityHigh):
+			default:
+			}
 		}
+		if ci == nil {
+			return
+		}
+		s.execCall(ci)
 	}
 }
 
@@ -92,6 +243,16 @@ func (s *Skeleton) Go(f func(), cb func()) {
 	s.g.Go(f, cb)
 }
 
+//同一个key（比如玩家id、房间id）的f按调用顺序串行执行，不同key并行执行
+//cb在本模块的goroutine中执行
+func (s *Skeleton) GoKeyed(key interface{}, f func(), cb func()) {
+	if s.GoLen == 0 {
+		panic("invalid GoLen")
+	}
+
+	s.keyed.Go(key, f, cb)
+}
+
 func (s *Skeleton) NewLinearContext() *g.LinearContext {
 	if s.GoLen == 0 {
 		panic("invalid GoLen")
@@ -109,14 +270,73 @@ func (s *Skeleton) AsyncCall(server *chanrpc.Server, id interface{}, args ...int
 	s.client.AsyncCall(id, args...)
 }
 
+//等待future完成，在本模块的goroutine中执行cb，需要GoLen
+func (s *Skeleton) Await(f *chanrpc.Future, cb func(interface{}, error)) {
+	var (
+		ret interface{}
+		err error
+	)
+	s.Go(func() {
+		ret, err = f.Wait()
+	}, func() {
+		cb(ret, err)
+	})
+}
+
 func (s *Skeleton) RegisterChanRPC(id interface{}, f interface{}) {
 	if s.ChanRPCServer == nil {
 		panic("invalid ChanRPCServer")
 	}
 
 	s.server.Register(id, f)
+	s.rpcIDs = append(s.rpcIDs, id)
+}
+
+//注册任意签名的函数，见chanrpc.Server.RegisterTyped
+func (s *Skeleton) RegisterTypedChanRPC(id interface{}, f interface{}) {
+	if s.ChanRPCServer == nil {
+		panic("invalid ChanRPCServer")
+	}
+
+	s.server.RegisterTyped(id, f)
+	s.rpcIDs = append(s.rpcIDs, id)
+}
+
+//订阅话题，f的定义同RegisterChanRPC或RegisterTypedChanRPC，在本模块的goroutine中执行
+//模块销毁时自动取消订阅
+func (s *Skeleton) Subscribe(topic string, f interface{}) {
+	switch f.(type) {
+	case func([]interface{}), func([]interface{}) interface{}, func([]interface{}) []interface{}:
+		s.server.Register(event.ID(topic), f)
+	default:
+		s.server.RegisterTyped(event.ID(topic), f)
+	}
+	s.rpcIDs = append(s.rpcIDs, event.ID(topic))
+	if s.running {
+		s.manager().bus.Subscribe(topic, s.server)
+	} else {
+		s.pendingTopics = append(s.pendingTopics, topic)
+	}
 }
 
+//Run之前注册的命令在Run开始时生效，会接管旧实例注册的同名命令
 func (s *Skeleton) RegisterCommand(name string, help string, f interface{}) {
-	console.Register(name, help, f, s.commandServer)
+	if !s.running {
+		s.pendingCommands = append(s.pendingCommands, command{name, help, f})
+		return
+	}
+	s.console().Register(name, help, f, s.commandServer)
+	s.commands = append(s.commands, name)
+}
+
+//注销本模块注册的chanrpc函数和console命令
+func (s *Skeleton) unregister() {
+	for _, id := range s.rpcIDs {
+		s.server.Unregister(id)
+	}
+	s.rpcIDs = nil
+	for _, name := range s.commands {
+		s.console().UnregisterFrom(name, s.commandServer)
+	}
+	s.commands = nil
 }
@@ -0,0 +1,169 @@
+package module
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/YiuTerran/leaf/log"
+	"go.uber.org/atomic"
+)
+
+//模块Run发生panic之后的处理方式
+type RestartMode int
+
+const (
+	RestartNever   RestartMode = iota //不重启，模块停止运行
+	RestartAlways                     //等待minRestartDelay后重启
+	RestartBackoff                    //等待一段时间后重启，等待时间每次翻倍
+)
+
+//两次重启之间最短的等待时间，避免Run立即panic时空转
+const minRestartDelay = 100 * time.Millisecond
+
+type RestartPolicy struct {
+	Mode        RestartMode
+	Backoff     time.Duration //RestartBackoff的初始等待时间，默认1秒
+	MaxBackoff  time.Duration //最长等待时间，默认1分钟
+	MaxRestarts int           //Window时间内最多重启的次数，0不限制
+	Window      time.Duration
+	Escalate    bool //不再重启时关闭整个服务器
+}
+
+//模块可以选择实现的接口，未实现时使用RestartNever
+//重启只重新执行Run，不会再调用OnInit
+type Supervised interface {
+	RestartPolicy() RestartPolicy
+}
+
+//模块不再重启且需要升级处理时调用，一般由leaf设置为关闭服务器
+func (mgr *Manager) SetEscalation(f func(name string)) {
+	mgr.mutexEscalate.Lock()
+	defer mgr.mutexEscalate.Unlock()
+	mgr.escalate = f
+}
+
+type supervisor struct {
+	restarts atomic.Int32
+	history  []time.Time
+	backoff  time.Duration
+}
+
+//执行一次Run，返回是否panic
+func runOnce(m *module) (panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			buf := make([]byte, log.LenStackBuf)
+			l := runtime.Stack(buf, false)
+			m.mgr.log().Error("module %s panic: %v: %s", m.mi.Name(), r, buf[:l])
+			panicked = true
+		}
+	}()
+
+	m.mi.Run(m.closeSig)
+	return false
+}
+
+//按重启策略等待，返回是否需要重启
+func (m *module) shouldRestart(policy RestartPolicy) bool {
+	if policy.Mode == RestartNever {
+		return false
+	}
+
+	now := time.Now()
+	if policy.MaxRestarts > 0 {
+		history := m.sup.history[:0]
+		for _, t := range m.sup.history {
+			if policy.Window <= 0 || now.Sub(t) < policy.Window {
+				history = append(history, t)
+			}
+		}
+		m.sup.history = history
+		if len(history) >= policy.MaxRestarts {
+			m.mgr.log().Error("module %s restarted %v times in %v, give up", m.mi.Name(), len(history), policy.Window)
+			return false
+		}
+	}
+
+	if policy.Mode == RestartAlways {
+		if !m.wait(minRestartDelay) {
+			return false
+		}
+	}
+
+	if policy.Mode == RestartBackoff {
+		if m.sup.backoff <= 0 {
+			m.sup.backoff = policy.Backoff
+			if m.sup.backoff <= 0 {
+				m.sup.backoff = time.Second
+			}
+		}
+		if !m.wait(m.sup.backoff) {
+			return false
+		}
+		maxBackoff := policy.MaxBackoff
+		if maxBackoff <= 0 {
+			maxBackoff = time.Minute
+		}
+		m.sup.backoff *= 2
+		if m.sup.backoff > maxBackoff {
+			m.sup.backoff = maxBackoff
+		}
+	}
+
+	select {
+	case <-m.stopping:
+		return false
+	default:
+	}
+	m.sup.history = append(m.sup.history, time.Now())
+	return true
+}
+
+//等待d，模块被关闭时返回false
+func (m *module) wait(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-m.stopping:
+		return false
+	}
+}
+
+func (m *module) supervise() {
+	var policy RestartPolicy
+	if s, ok := m.mi.(Supervised); ok {
+		policy = s.RestartPolicy()
+	}
+
+	for runOnce(m) {
+		m.state.CAS(int32(StateRunning), int32(StateRestarting))
+		if !m.shouldRestart(policy) {
+			select {
+			case <-m.stopping:
+			default:
+				m.state.CAS(int32(StateRestarting), int32(StateStopped))
+				m.mgr.log().Error("module %s stopped after panic", m.mi.Name())
+				if policy.Escalate {
+					m.mgr.mutexEscalate.Lock()
+					f := m.mgr.escalate
+					m.mgr.mutexEscalate.Unlock()
+					if f != nil {
+						f(m.mi.Name())
+					}
+				}
+			}
+			return
+		}
+		m.state.CAS(int32(StateRestarting), int32(StateRunning))
+		n := m.sup.restarts.Inc()
+		m.mgr.log().Warn("module %s restarting, restarts: %v", m.mi.Name(), n)
+	}
+}
+
+//模块因panic重启的次数
+func (mgr *Manager) Restarts(name string) int {
+	info, _ := mgr.Lookup(name)
+	return info.Restarts
+}
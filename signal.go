@@ -0,0 +1,133 @@
+package leaf
+
+import (
+	"os"
+	"os/signal"
+)
+
+//收到信号之后的处理方式
+type SignalAction int
+
+const (
+	SignalIgnore SignalAction = iota
+	SignalStop                //优雅关闭服务器，关闭过程中再次收到时立即退出
+	SignalReload              //热加载模块，StaticRun下忽略
+	SignalHook                //执行SetSignalHook设置的函数
+)
+
+//设置信号的处理方式，需要在Run之前调用
+//默认SIGTERM和SIGINT关闭服务器，SIGHUP热加载，SIGUSR1重新打开日志文件，SIGUSR2切换debug日志
+//SIGKILL无法捕获，不需要设置
+func (a *App) HandleSignal(sig os.Signal, action SignalAction) {
+	if sig == os.Kill {
+		a.Logger().Warn("signal %v can not be handled", sig)
+		return
+	}
+	a.mutexSignals.Lock()
+	defer a.mutexSignals.Unlock()
+	//忽略的信号也要监听，否则会执行系统默认的处理，比如SIGHUP会终止进程
+	a.signalActions[sig] = action
+	if action != SignalHook {
+		delete(a.signalHooks, sig)
+	}
+}
+
+//收到sig时在信号处理的goroutine中执行f，f为nil时忽略该信号
+func (a *App) SetSignalHook(sig os.Signal, f func()) {
+	if f == nil {
+		a.HandleSignal(sig, SignalIgnore)
+		return
+	}
+	a.HandleSignal(sig, SignalHook)
+	a.mutexSignals.Lock()
+	defer a.mutexSignals.Unlock()
+	a.signalHooks[sig] = f
+}
+
+//见App.HandleSignal
+func HandleSignal(sig os.Signal, action SignalAction) {
+	std.HandleSignal(sig, action)
+}
+
+//见App.SetSignalHook
+func SetSignalHook(sig os.Signal, f func()) {
+	std.SetSignalHook(sig, f)
+}
+
+func (a *App) reopenLog() {
+	l := a.Logger()
+	if err := l.Reopen(); err != nil {
+		l.Error("reopen log failed: %v", err)
+		return
+	}
+	l.Info("log reopened")
+}
+
+func (a *App) toggleDebug() {
+	l := a.Logger()
+	l.EnableDebug(!l.IsDebug())
+	l.Info("debug log enabled: %v", l.IsDebug())
+}
+
+//返回停止监听的函数，需要在服务器完全关闭之后调用，以便关闭过程中再次收到信号时强制退出
+func (a *App) notifySignals(static bool) func() {
+	a.mutexSignals.Lock()
+	actions := make(map[os.Signal]SignalAction, len(a.signalActions))
+	sigs := make([]os.Signal, 0, len(a.signalActions))
+	for sig, action := range a.signalActions {
+		actions[sig] = action
+		sigs = append(sigs, sig)
+	}
+	a.mutexSignals.Unlock()
+
+	//每种信号都有位置，同时收到不同的信号时不会丢失
+	ch := make(chan os.Signal, len(sigs)+1)
+	done := make(chan struct{})
+	//没有参数时会监听所有信号
+	if len(sigs) > 0 {
+		signal.Notify(ch, sigs...)
+	}
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case sig := <-ch:
+				a.handleSignal(sig, actions[sig], static)
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(ch)
+		close(done)
+	}
+}
+
+func (a *App) handleSignal(sig os.Signal, action SignalAction, static bool) {
+	l := a.Logger()
+	switch action {
+	case SignalIgnore:
+		l.Debug("signal %v ignored", sig)
+	case SignalStop:
+		if !a.stop(sig) {
+			l.Error("signal %v received again, exit immediately", sig)
+			l.Close()
+			os.Exit(1)
+		}
+		l.Info("signal %v received, closing server", sig)
+	case SignalReload:
+		if static {
+			l.Warn("signal %v received, but reload is not supported in static mode", sig)
+			return
+		}
+		l.Info("signal %v received, reloading", sig)
+		a.Reload()
+	case SignalHook:
+		a.mutexSignals.Lock()
+		f := a.signalHooks[sig]
+		a.mutexSignals.Unlock()
+		if f != nil {
+			f()
+		}
+	}
+}
@@ -0,0 +1,43 @@
+//go:build !windows
+// +build !windows
+
+package leaf
+
+import (
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/YiuTerran/leaf/log"
+)
+
+func TestSignalIgnore(t *testing.T) {
+	log.InitLogger("")
+	a := NewApp()
+	a.HandleSignal(syscall.SIGHUP, SignalIgnore)
+	a.SetSignalHook(syscall.SIGUSR1, nil)
+	hooked := make(chan struct{}, 1)
+	a.SetSignalHook(syscall.SIGUSR2, func() {
+		hooked <- struct{}{}
+	})
+	stop := a.notifySignals(false)
+	defer stop()
+
+	//忽略的信号如果没有监听，会按系统默认处理终止进程
+	for _, sig := range []syscall.Signal{syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2} {
+		if err := syscall.Kill(syscall.Getpid(), sig); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case <-hooked:
+	case <-time.After(time.Second):
+		t.Fatal("expected the hook of SIGUSR2")
+	}
+	time.Sleep(10 * time.Millisecond)
+	select {
+	case <-a.internalChannel:
+		t.Fatal("ignored SIGHUP must not reload")
+	default:
+	}
+}
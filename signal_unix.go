@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package leaf
+
+import "syscall"
+
+func (a *App) defaultSignals() {
+	a.signalActions[syscall.SIGTERM] = SignalStop
+	a.signalActions[syscall.SIGINT] = SignalStop
+	a.signalActions[syscall.SIGHUP] = SignalReload
+	a.signalActions[syscall.SIGUSR1] = SignalHook
+	a.signalHooks[syscall.SIGUSR1] = a.reopenLog
+	a.signalActions[syscall.SIGUSR2] = SignalHook
+	a.signalHooks[syscall.SIGUSR2] = a.toggleDebug
+}
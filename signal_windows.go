@@ -0,0 +1,9 @@
+package leaf
+
+import "syscall"
+
+//windows下只支持关闭服务器
+func (a *App) defaultSignals() {
+	a.signalActions[syscall.SIGTERM] = SignalStop
+	a.signalActions[syscall.SIGINT] = SignalStop
+}
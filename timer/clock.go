@@ -0,0 +1,164 @@
+package timer
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+//Dispatcher使用的时钟，测试时可以用ManualClock代替系统时钟
+type Clock interface {
+	Now() time.Time
+	//d之后在其他goroutine中调用f
+	AfterFunc(d time.Duration, f func()) Stopper
+}
+
+type Stopper interface {
+	Stop() bool
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Stopper {
+	return time.AfterFunc(d, f)
+}
+
+//系统时钟
+var RealClock Clock = realClock{}
+
+//手动推进的时钟，定时器只在Advance或者Set时按时间顺序在调用者的goroutine中触发
+// goroutine safe
+type ManualClock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	seq    uint64
+	timers manualTimers
+}
+
+func NewManualClock(now time.Time) *ManualClock {
+	c := new(ManualClock)
+	c.now = now
+	return c
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *ManualClock) AfterFunc(d time.Duration, f func()) Stopper {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if d < 0 {
+		d = 0
+	}
+	c.seq++
+	t := &manualTimer{clock: c, when: c.now.Add(d), seq: c.seq, f: f}
+	heap.Push(&c.timers, t)
+	return t
+}
+
+//最早的定时器的触发时间，没有定时器时返回false
+func (c *ManualClock) Next() (time.Time, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(c.timers) == 0 {
+		return time.Time{}, false
+	}
+	return c.timers[0].when, true
+}
+
+//等待中的定时器数量
+func (c *ManualClock) Pending() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.timers)
+}
+
+//时间前进d，返回触发的定时器数量
+func (c *ManualClock) Advance(d time.Duration) int {
+	return c.Set(c.Now().Add(d))
+}
+
+//把时间设置为t，按顺序触发t之前（包括t）的定时器，回调中新建的到期定时器也会触发
+//时间不会后退，返回触发的定时器数量
+func (c *ManualClock) Set(t time.Time) int {
+	fired := 0
+	for {
+		c.mutex.Lock()
+		if len(c.timers) == 0 || c.timers[0].when.After(t) {
+			if t.After(c.now) {
+				c.now = t
+			}
+			c.mutex.Unlock()
+			return fired
+		}
+		timer := heap.Pop(&c.timers).(*manualTimer)
+		if timer.when.After(c.now) {
+			c.now = timer.when
+		}
+		c.mutex.Unlock()
+
+		timer.f()
+		fired++
+	}
+}
+
+type manualTimer struct {
+	clock *ManualClock
+	when  time.Time
+	seq   uint64 //同一时间按创建顺序触发
+	index int
+	f     func()
+}
+
+func (t *manualTimer) Stop() bool {
+	c := t.clock
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if t.index < 0 {
+		return false
+	}
+	heap.Remove(&c.timers, t.index)
+	return true
+}
+
+type manualTimers []*manualTimer
+
+func (ts manualTimers) Len() int {
+	return len(ts)
+}
+
+func (ts manualTimers) Less(i, j int) bool {
+	if ts[i].when.Equal(ts[j].when) {
+		return ts[i].seq < ts[j].seq
+	}
+	return ts[i].when.Before(ts[j].when)
+}
+
+func (ts manualTimers) Swap(i, j int) {
+	ts[i], ts[j] = ts[j], ts[i]
+	ts[i].index = i
+	ts[j].index = j
+}
+
+func (ts *manualTimers) Push(x interface{}) {
+	t := x.(*manualTimer)
+	t.index = len(*ts)
+	*ts = append(*ts, t)
+}
+
+func (ts *manualTimers) Pop() interface{} {
+	old := *ts
+	n := len(old)
+	t := old[n-1]
+	old[n-1] = nil
+	t.index = -1
+	*ts = old[:n-1]
+	return t
+}
@@ -9,14 +9,25 @@ import (
 	"time"
 )
 
-// Field name   | Mandatory? | Allowed values | Allowed special characters
-// ----------   | ---------- | -------------- | --------------------------
-// Seconds      | No         | 0-59           | * / , -
-// Minutes      | Yes        | 0-59           | * / , -
-// Hours        | Yes        | 0-23           | * / , -
-// Day of month | Yes        | 1-31           | * / , -
-// Month        | Yes        | 1-12           | * / , -
-// Day of week  | Yes        | 0-6            | * / , -
+// Field name   | Mandatory? | Allowed values  | Allowed special characters
+// ----------   | ---------- | --------------  | --------------------------
+// Seconds      | No         | 0-59            | * / , -
+// Minutes      | Yes        | 0-59            | * / , -
+// Hours        | Yes        | 0-23            | * / , -
+// Day of month | Yes        | 1-31            | * / , - ? L W
+// Month        | Yes        | 1-12 or JAN-DEC | * / , -
+// Day of week  | Yes        | 0-7 or SUN-SAT  | * / , - ? L #
+//
+// L in day of month is the last day, L-n is n days before it, nW is the weekday
+// nearest to day n in the same month and LW is the last weekday of the month.
+// nL in day of week is the last weekday n of the month, n#k is the k-th one.
+// 7 in day of week is also Sunday, ? is the same as *.
+//
+// The expression may start with TZ=<location> (or CRON_TZ=<location>) to be
+// evaluated in that time zone, and the macros below are supported:
+// @yearly (@annually), @monthly, @weekly, @daily (@midnight), @hourly and
+// @every <duration> which fires every duration (at least one second) from the
+// given time.
 type CronExpr struct {
 	sec   uint64
 	min   uint64
@@ -24,11 +35,77 @@ type CronExpr struct {
 	dom   uint64
 	month uint64
 	dow   uint64
+
+	loc   *time.Location
+	every time.Duration
+
+	// day of month modifiers
+	lastDom     []int // L and L-n, the offset from the last day
+	weekdayDom  []int // nW
+	lastWeekday bool  // LW
+	// day of week modifiers
+	lastDow uint64   // nL
+	nthDow  [][2]int // n#k
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 0 1 1 *",
+	"@annually": "0 0 0 1 1 *",
+	"@monthly":  "0 0 0 1 * *",
+	"@weekly":   "0 0 0 * * 0",
+	"@daily":    "0 0 0 * * *",
+	"@midnight": "0 0 0 * * *",
+	"@hourly":   "0 0 * * * *",
 }
 
+var (
+	monthNames = []string{"JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC"}
+	dowNames   = []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}
+)
+
 // goroutine safe
 func NewCronExpr(expr string) (cronExpr *CronExpr, err error) {
-	fields := strings.Fields(expr)
+	return NewCronExprIn(expr, nil)
+}
+
+// the expression is evaluated in loc unless it starts with TZ=,
+// nil loc means the location of the time passed to Next
+// goroutine safe
+func NewCronExprIn(expr string, loc *time.Location) (cronExpr *CronExpr, err error) {
+	spec := strings.TrimSpace(expr)
+	if strings.HasPrefix(spec, "TZ=") || strings.HasPrefix(spec, "CRON_TZ=") {
+		i := strings.IndexAny(spec, " \t")
+		if i < 0 {
+			err = fmt.Errorf("invalid expr %v: missing fields after time zone", expr)
+			return
+		}
+		name := spec[strings.Index(spec, "=")+1 : i]
+		loc, err = time.LoadLocation(name)
+		if err != nil {
+			err = fmt.Errorf("invalid expr %v: %v", expr, err)
+			return
+		}
+		spec = strings.TrimSpace(spec[i:])
+	}
+
+	if strings.HasPrefix(spec, "@every ") {
+		var every time.Duration
+		every, err = time.ParseDuration(strings.TrimSpace(spec[len("@every "):]))
+		if err != nil {
+			err = fmt.Errorf("invalid expr %v: %v", expr, err)
+			return
+		}
+		if every < time.Second {
+			every = time.Second
+		}
+		cronExpr = &CronExpr{loc: loc, every: every.Truncate(time.Second)}
+		return
+	}
+	if macro, ok := cronMacros[spec]; ok {
+		spec = macro
+	}
+
+	fields := strings.Fields(spec)
 	if len(fields) != 5 && len(fields) != 6 {
 		err = fmt.Errorf("invalid expr %v: expected 5 or 6 fields, got %v", expr, len(fields))
 		return
@@ -39,6 +116,7 @@ func NewCronExpr(expr string) (cronExpr *CronExpr, err error) {
 	}
 
 	cronExpr = new(CronExpr)
+	cronExpr.loc = loc
 	// Seconds
 	cronExpr.sec, err = parseCronField(fields[0], 0, 59)
 	if err != nil {
@@ -55,17 +133,17 @@ func NewCronExpr(expr string) (cronExpr *CronExpr, err error) {
 		goto onError
 	}
 	// Day of month
-	cronExpr.dom, err = parseCronField(fields[3], 1, 31)
+	err = cronExpr.parseDom(fields[3])
 	if err != nil {
 		goto onError
 	}
 	// Month
-	cronExpr.month, err = parseCronField(fields[4], 1, 12)
+	cronExpr.month, err = parseCronField(replaceNames(fields[4], monthNames, 1), 1, 12)
 	if err != nil {
 		goto onError
 	}
 	// Day of week
-	cronExpr.dow, err = parseCronField(fields[5], 0, 6)
+	err = cronExpr.parseDow(replaceNames(fields[5], dowNames, 0))
 	if err != nil {
 		goto onError
 	}
@@ -76,6 +154,121 @@ onError:
 	return
 }
 
+// replace the names with their numbers, the first name is offset
+func replaceNames(field string, names []string, offset int) string {
+	field = strings.ToUpper(field)
+	for i, name := range names {
+		field = strings.Replace(field, name, strconv.Itoa(i+offset), -1)
+	}
+	return field
+}
+
+// split the items with modifiers from the field, the rest is parsed by parseCronField
+func splitSpecial(field string, special func(item string) bool) (rest string, specials []string) {
+	if field == "?" {
+		return "*", nil
+	}
+	var items []string
+	for _, item := range strings.Split(field, ",") {
+		if special(item) {
+			specials = append(specials, item)
+		} else {
+			items = append(items, item)
+		}
+	}
+	return strings.Join(items, ","), specials
+}
+
+func (e *CronExpr) parseDom(field string) (err error) {
+	rest, specials := splitSpecial(strings.ToUpper(field), func(item string) bool {
+		return strings.ContainsAny(item, "LW")
+	})
+	if rest != "" {
+		e.dom, err = parseCronField(rest, 1, 31)
+		if err != nil {
+			return
+		}
+	}
+	for _, item := range specials {
+		switch {
+		case item == "LW":
+			e.lastWeekday = true
+		case item == "L":
+			e.lastDom = append(e.lastDom, 0)
+		case strings.HasPrefix(item, "L-"):
+			offset, err := strconv.Atoi(item[2:])
+			if err != nil || offset < 0 || offset > 30 {
+				return fmt.Errorf("invalid last day: %v", item)
+			}
+			e.lastDom = append(e.lastDom, offset)
+		case strings.HasSuffix(item, "W"):
+			day, err := strconv.Atoi(item[:len(item)-1])
+			if err != nil || day < 1 || day > 31 {
+				return fmt.Errorf("invalid weekday: %v", item)
+			}
+			e.weekdayDom = append(e.weekdayDom, day)
+		default:
+			return fmt.Errorf("invalid day of month: %v", item)
+		}
+	}
+	return
+}
+
+func (e *CronExpr) parseDow(field string) (err error) {
+	rest, specials := splitSpecial(field, func(item string) bool {
+		return strings.ContainsAny(item, "L#")
+	})
+	if rest != "" {
+		for _, item := range strings.Split(rest, ",") {
+			// 7 is also Sunday, but only when written explicitly:
+			// steps and open-ended ranges still stop at 6
+			max := 6
+			r := strings.SplitN(item, "/", 2)[0]
+			if r == "7" || strings.HasSuffix(r, "-7") {
+				max = 7
+			}
+			var dow uint64
+			dow, err = parseCronField(item, 0, max)
+			if err != nil {
+				return
+			}
+			if dow&(1<<7) != 0 {
+				dow = dow&^(1<<7) | 1
+			}
+			e.dow |= dow
+		}
+	}
+	for _, item := range specials {
+		if strings.HasSuffix(item, "L") {
+			dow, err := strconv.Atoi(item[:len(item)-1])
+			if err != nil || dow < 0 || dow > 7 {
+				return fmt.Errorf("invalid last weekday: %v", item)
+			}
+			e.lastDow |= 1 << uint(dow%7)
+			continue
+		}
+		dowAndNth := strings.Split(item, "#")
+		if len(dowAndNth) != 2 {
+			return fmt.Errorf("invalid day of week: %v", item)
+		}
+		dow, err := strconv.Atoi(dowAndNth[0])
+		if err != nil || dow < 0 || dow > 7 {
+			return fmt.Errorf("invalid day of week: %v", item)
+		}
+		nth, err := strconv.Atoi(dowAndNth[1])
+		if err != nil || nth < 1 || nth > 5 {
+			return fmt.Errorf("invalid day of week: %v", item)
+		}
+		e.nthDow = append(e.nthDow, [2]int{dow % 7, nth})
+	}
+	return
+}
+
+// the location of the expression, nil if it is evaluated in the location of the given time
+func (e *CronExpr) Location() *time.Location {
+	return e.loc
+}
+
 // 1. *
 // 2. num
 // 3. num-num
@@ -171,23 +364,96 @@ func parseCronField(field string, min int, max int) (cronField uint64, err error
 	return
 }
 
+func (e *CronExpr) hasDomSpecial() bool {
+	return len(e.lastDom) > 0 || len(e.weekdayDom) > 0 || e.lastWeekday
+}
+
+func (e *CronExpr) hasDowSpecial() bool {
+	return e.lastDow != 0 || len(e.nthDow) > 0
+}
+
+func (e *CronExpr) matchDom(t time.Time) bool {
+	if 1<<uint(t.Day())&e.dom != 0 {
+		return true
+	}
+	if !e.hasDomSpecial() {
+		return false
+	}
+	day := t.Day()
+	lastDay := time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, t.Location()).Day()
+	for _, offset := range e.lastDom {
+		if day == lastDay-offset {
+			return true
+		}
+	}
+	for _, n := range e.weekdayDom {
+		if n <= lastDay && day == nearestWeekday(t, n, lastDay) {
+			return true
+		}
+	}
+	return e.lastWeekday && day == nearestWeekday(t, lastDay, lastDay)
+}
+
+// the weekday nearest to day n in the month of t, not crossing the month
+func nearestWeekday(t time.Time, n int, lastDay int) int {
+	switch time.Date(t.Year(), t.Month(), n, 0, 0, 0, 0, t.Location()).Weekday() {
+	case time.Saturday:
+		if n == 1 {
+			return n + 2
+		}
+		return n - 1
+	case time.Sunday:
+		if n == lastDay {
+			return n - 2
+		}
+		return n + 1
+	}
+	return n
+}
+
+func (e *CronExpr) matchDow(t time.Time) bool {
+	weekday := t.Weekday()
+	if 1<<uint(weekday)&e.dow != 0 {
+		return true
+	}
+	if !e.hasDowSpecial() {
+		return false
+	}
+	if 1<<uint(weekday)&e.lastDow != 0 &&
+		t.AddDate(0, 0, 7).Month() != t.Month() {
+		return true
+	}
+	for _, dowAndNth := range e.nthDow {
+		if int(weekday) == dowAndNth[0] && (t.Day()-1)/7+1 == dowAndNth[1] {
+			return true
+		}
+	}
+	return false
+}
+
 func (e *CronExpr) matchDay(t time.Time) bool {
 	// day-of-month blank
-	if e.dom == 0xfffffffe {
-		return 1<<uint(t.Weekday())&e.dow != 0
+	if e.dom == 0xfffffffe && !e.hasDomSpecial() {
+		return e.matchDow(t)
 	}
 
 	// day-of-week blank
-	if e.dow == 0x7f {
-		return 1<<uint(t.Day())&e.dom != 0
+	if e.dow == 0x7f && !e.hasDowSpecial() {
+		return e.matchDom(t)
 	}
 
-	return 1<<uint(t.Weekday())&e.dow != 0 ||
-		1<<uint(t.Day())&e.dom != 0
+	return e.matchDow(t) || e.matchDom(t)
 }
 
 // goroutine safe
 func (e *CronExpr) Next(t time.Time) time.Time {
+	if e.loc != nil {
+		t = t.In(e.loc)
+	}
+	if e.every > 0 {
+		return t.Truncate(time.Second).Add(e.every)
+	}
+
 	// the upcoming second
 	t = t.Truncate(time.Second).Add(time.Second)
 
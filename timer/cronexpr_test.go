@@ -0,0 +1,72 @@
+package timer_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/YiuTerran/leaf/timer"
+)
+
+func TestCronExprNext(t *testing.T) {
+	from := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC) // Friday
+	tests := []struct {
+		expr string
+		next string
+	}{
+		{"@yearly", "2022-01-01 00:00:00"},
+		{"@monthly", "2021-02-01 00:00:00"},
+		{"@weekly", "2021-01-03 00:00:00"},
+		{"@daily", "2021-01-02 00:00:00"},
+		{"@hourly", "2021-01-01 01:00:00"},
+		{"@every 5m", "2021-01-01 00:05:00"},
+		{"0 0 1 FEB,mar ?", "2021-02-01 00:00:00"},
+		{"0 0 ? * MON-WED", "2021-01-04 00:00:00"},
+		{"0 0 ? * 7", "2021-01-03 00:00:00"},
+		{"0 0 ? * 5-7", "2021-01-02 00:00:00"},
+		{"0 0 ? * 1/2", "2021-01-04 00:00:00"}, // 1,3,5, not Sunday
+		{"0 0 ? * 2/5", "2021-01-05 00:00:00"}, // only 2
+		{"0 0 L * ?", "2021-01-31 00:00:00"},
+		{"0 0 L-2 2 ?", "2021-02-26 00:00:00"},
+		{"0 0 2W * ?", "2021-02-02 00:00:00"},  // 2nd is Saturday, 1st is not after from
+		{"0 0 16W * ?", "2021-01-15 00:00:00"}, // 16th is Saturday
+		{"0 0 LW 2 ?", "2021-02-26 00:00:00"},  // 28th is Sunday
+		{"0 0 ? * 5L", "2021-01-29 00:00:00"},
+		{"0 0 ? * FRI#2", "2021-01-08 00:00:00"},
+		{"0 0 ? * 1#5", "2021-03-29 00:00:00"},
+		{"TZ=Asia/Shanghai 0 5 * * *", "2021-01-01 21:00:00"},
+		{"CRON_TZ=America/New_York 0 0 * * *", "2021-01-01 05:00:00"},
+	}
+	for _, test := range tests {
+		cronExpr, err := timer.NewCronExpr(test.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		next := cronExpr.Next(from).UTC().Format("2006-01-02 15:04:05")
+		if next != test.next {
+			t.Errorf("%v: expected %v, got %v", test.expr, test.next, next)
+		}
+	}
+}
+
+func TestCronExprIn(t *testing.T) {
+	loc := time.FixedZone("UTC+8", 8*3600)
+	cronExpr, err := timer.NewCronExprIn("0 5 * * *", loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := cronExpr.Next(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	if !next.Equal(time.Date(2021, 1, 1, 21, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected next %v", next)
+	}
+}
+
+func TestCronExprInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"@every x", "TZ=Mars/Base 0 * * * *", "0 0 L-40 * ?", "0 0 32W * ?",
+		"0 0 ? * 8L", "0 0 ? * 1#6", "0 0 ? * 1#", "0 0 X * ?", "0 0 * FOO *",
+	} {
+		if _, err := timer.NewCronExpr(expr); err == nil {
+			t.Errorf("%v: expected error", expr)
+		}
+	}
+}
@@ -65,3 +65,41 @@ func ExampleCron() {
 	// Output:
 	// My name is Leaf
 }
+
+func ExampleManualClock() {
+	clock := timer.NewManualClock(time.Date(2000, 1, 1, 4, 0, 0, 0, time.UTC))
+	d := timer.NewDispatcherWithClock(10, clock)
+
+	// a cooldown of one hour
+	d.AfterFunc(time.Hour, func() {
+		fmt.Println("cooldown over at", clock.Now().Format("15:04"))
+	})
+
+	// a daily cron at 05:00
+	cronExpr, err := timer.NewCronExpr("0 5 * * *")
+	if err != nil {
+		return
+	}
+	d.CronFunc(cronExpr, func() {
+		fmt.Println("daily reset at", clock.Now().Format("Jan 2 15:04"))
+	})
+
+	// dispatch the timers one instant after another for 3 days
+	end := clock.Now().Add(3 * 24 * time.Hour)
+	for {
+		next, ok := clock.Next()
+		if !ok || next.After(end) {
+			break
+		}
+		clock.Set(next)
+		for len(d.ChanTimer) > 0 {
+			(<-d.ChanTimer).Cb()
+		}
+	}
+
+	// Output:
+	// cooldown over at 05:00
+	// daily reset at Jan 1 05:00
+	// daily reset at Jan 2 05:00
+	// daily reset at Jan 3 05:00
+}
@@ -10,22 +10,58 @@ import (
 // one dispatcher per goroutine (goroutine not safe)
 type Dispatcher struct {
 	ChanTimer chan *Timer
+	clock     Clock
+	wheel     *Wheel
 }
 
 func NewDispatcher(l int) *Dispatcher {
+	return NewDispatcherWithClock(l, RealClock)
+}
+
+//使用指定时钟的Dispatcher，clock为nil时使用系统时钟
+func NewDispatcherWithClock(l int, clock Clock) *Dispatcher {
+	if clock == nil {
+		clock = RealClock
+	}
 	disp := new(Dispatcher)
 	disp.ChanTimer = make(chan *Timer, l)
+	disp.clock = clock
 	return disp
 }
 
+//定时器由时间轮触发的Dispatcher，多个Dispatcher可以共用一个时间轮
+func NewWheelDispatcher(l int, wheel *Wheel) *Dispatcher {
+	disp := NewDispatcher(l)
+	disp.wheel = wheel
+	return disp
+}
+
+func (disp *Dispatcher) Clock() Clock {
+	return disp.clock
+}
+
 // Timer
 type Timer struct {
-	t  *time.Timer
-	cb func()
+	t      Stopper
+	cb     func()
+	origin func() //cron的原始回调，用于诊断
+	node   wheelNode
+}
+
+//定时器的回调，用于诊断，执行或者停止之后返回nil
+func (t *Timer) Func() func() {
+	if t.cb == nil || t.origin == nil {
+		return t.cb
+	}
+	return t.origin
 }
 
 func (t *Timer) Stop() {
-	t.t.Stop()
+	if t.node.wheel != nil {
+		t.node.wheel.remove(t)
+	} else {
+		t.t.Stop()
+	}
 	t.cb = nil
 }
 
@@ -47,7 +83,11 @@ func (t *Timer) Cb() {
 func (disp *Dispatcher) AfterFunc(d time.Duration, cb func()) *Timer {
 	t := new(Timer)
 	t.cb = cb
-	t.t = time.AfterFunc(d, func() {
+	if disp.wheel != nil {
+		disp.wheel.add(t, disp, d)
+		return t
+	}
+	t.t = disp.clock.AfterFunc(d, func() {
 		disp.ChanTimer <- t
 	})
 	return t
@@ -67,7 +107,7 @@ func (c *Cron) Stop() {
 func (disp *Dispatcher) CronFunc(cronExpr *CronExpr, _cb func()) *Cron {
 	c := new(Cron)
 
-	now := time.Now()
+	now := disp.clock.Now()
 	nextTime := cronExpr.Next(now)
 	if nextTime.IsZero() {
 		return c
@@ -78,14 +118,16 @@ func (disp *Dispatcher) CronFunc(cronExpr *CronExpr, _cb func()) *Cron {
 	cb = func() {
 		defer _cb()
 
-		now := time.Now()
+		now := disp.clock.Now()
 		nextTime := cronExpr.Next(now)
 		if nextTime.IsZero() {
 			return
 		}
 		c.t = disp.AfterFunc(nextTime.Sub(now), cb)
+		c.t.origin = _cb
 	}
 
 	c.t = disp.AfterFunc(nextTime.Sub(now), cb)
+	c.t.origin = _cb
 	return c
 }
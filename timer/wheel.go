@@ -0,0 +1,216 @@
+package timer
+
+import (
+	"sync"
+	"time"
+)
+
+//分层时间轮：第一层256个槽，每个槽一个tick，后面三层各64个槽，每个槽是上一层一圈的时间
+//超出范围的定时器放在最后一层，降级时重新计算位置
+const (
+	wheelRootBits  = 8
+	wheelLevelBits = 6
+	wheelLevels    = 3
+	wheelRootSize  = 1 << wheelRootBits
+	wheelLevelSize = 1 << wheelLevelBits
+	wheelRootMask  = wheelRootSize - 1
+	wheelLevelMask = wheelLevelSize - 1
+	wheelMaxDelta  = 1<<(wheelRootBits+wheelLevelBits*wheelLevels) - 1
+)
+
+//定时器在时间轮中的位置，由Wheel的锁保护
+type wheelNode struct {
+	wheel  *Wheel
+	disp   *Dispatcher
+	expire uint64 //到期的tick
+	bucket *bucket
+	prev   *Timer
+	next   *Timer
+}
+
+type bucket struct {
+	head *Timer
+	tail *Timer
+}
+
+func (b *bucket) push(t *Timer) {
+	n := &t.node
+	n.bucket = b
+	n.prev = b.tail
+	n.next = nil
+	if b.tail != nil {
+		b.tail.node.next = t
+	} else {
+		b.head = t
+	}
+	b.tail = t
+}
+
+func (b *bucket) remove(t *Timer) {
+	n := &t.node
+	if n.prev != nil {
+		n.prev.node.next = n.next
+	} else {
+		b.head = n.next
+	}
+	if n.next != nil {
+		n.next.node.prev = n.prev
+	} else {
+		b.tail = n.prev
+	}
+	n.bucket, n.prev, n.next = nil, nil, nil
+}
+
+//取出所有定时器，按加入的顺序
+func (b *bucket) takeAll(ts []*Timer) []*Timer {
+	for t := b.head; t != nil; {
+		next := t.node.next
+		t.node.bucket, t.node.prev, t.node.next = nil, nil, nil
+		ts = append(ts, t)
+		t = next
+	}
+	b.head, b.tail = nil, nil
+	return ts
+}
+
+//时间轮，添加和取消定时器都是O(1)，每个tick在同一个goroutine中批量把到期的定时器发送到ChanTimer
+//定时器会在到期之后的一个tick内触发，某个Dispatcher的ChanTimer满时会阻塞共用时间轮的其他Dispatcher
+// goroutine safe
+type Wheel struct {
+	tick     time.Duration
+	mutex    sync.Mutex
+	current  uint64
+	root     [wheelRootSize]bucket
+	levels   [wheelLevels][wheelLevelSize]bucket
+	batch    []*Timer
+	closeSig chan struct{}
+	once     sync.Once
+}
+
+//创建并启动时间轮，tick为精度，不再使用时需要Close
+func NewWheel(tick time.Duration) *Wheel {
+	w := newWheel(tick)
+	go w.run()
+	return w
+}
+
+func newWheel(tick time.Duration) *Wheel {
+	if tick <= 0 {
+		tick = 10 * time.Millisecond
+	}
+	w := new(Wheel)
+	w.tick = tick
+	w.closeSig = make(chan struct{})
+	return w
+}
+
+func (w *Wheel) Tick() time.Duration {
+	return w.tick
+}
+
+//停止时间轮，尚未触发的定时器不再触发
+func (w *Wheel) Close() {
+	w.once.Do(func() {
+		close(w.closeSig)
+	})
+}
+
+func (w *Wheel) add(t *Timer, disp *Dispatcher, d time.Duration) {
+	ticks := uint64((d + w.tick - 1) / w.tick)
+	if d <= 0 || ticks == 0 {
+		ticks = 1
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	t.node.wheel = w
+	t.node.disp = disp
+	t.node.expire = w.current + ticks
+	w.place(t)
+}
+
+func (w *Wheel) place(t *Timer) {
+	expire := t.node.expire
+	delta := expire - w.current
+	if expire < w.current {
+		//降级时不会出现，保险起见下一个tick触发
+		expire = w.current
+		delta = 0
+	}
+	var b *bucket
+	switch {
+	case delta < wheelRootSize:
+		b = &w.root[expire&wheelRootMask]
+	default:
+		if delta > wheelMaxDelta {
+			expire = w.current + wheelMaxDelta
+		}
+		shift := uint(wheelRootBits)
+		level := 0
+		for ; level < wheelLevels-1; level++ {
+			if delta < 1<<(shift+wheelLevelBits) {
+				break
+			}
+			shift += wheelLevelBits
+		}
+		b = &w.levels[level][(expire>>shift)&wheelLevelMask]
+	}
+	b.push(t)
+}
+
+func (w *Wheel) remove(t *Timer) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if b := t.node.bucket; b != nil {
+		b.remove(t)
+	}
+}
+
+//把高层的一个槽重新放到低层
+func (w *Wheel) cascade(level int, index uint64) {
+	w.batch = w.levels[level][index].takeAll(w.batch[:0])
+	for _, t := range w.batch {
+		w.place(t)
+	}
+}
+
+//前进一个tick，返回到期的定时器，调用者在锁外使用
+func (w *Wheel) advance() []*Timer {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.current++
+	shift := uint(wheelRootBits)
+	for level := 0; level < wheelLevels; level++ {
+		if w.current&(1<<shift-1) != 0 {
+			break
+		}
+		w.cascade(level, (w.current>>shift)&wheelLevelMask)
+		shift += wheelLevelBits
+	}
+	w.batch = w.root[w.current&wheelRootMask].takeAll(w.batch[:0])
+	return w.batch
+}
+
+//前进一个tick并把到期的定时器发送到各自的ChanTimer
+func (w *Wheel) step() {
+	for _, t := range w.advance() {
+		t.node.disp.ChanTimer <- t
+	}
+}
+
+func (w *Wheel) run() {
+	ticker := time.NewTicker(w.tick)
+	defer ticker.Stop()
+	start := time.Now()
+	for {
+		select {
+		case <-w.closeSig:
+			return
+		case now := <-ticker.C:
+			//落后时追上
+			target := uint64(now.Sub(start) / w.tick)
+			for w.current < target {
+				w.step()
+			}
+		}
+	}
+}
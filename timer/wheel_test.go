@@ -0,0 +1,83 @@
+package timer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWheel(t *testing.T) {
+	w := newWheel(time.Millisecond)
+	disp := NewWheelDispatcher(10, w)
+
+	var fired []time.Duration
+	delays := []time.Duration{1<<20 + 300, 1, 255, 256, 1 << 14, 1<<20 + 3, 70000}
+	for _, d := range delays {
+		d := d * time.Millisecond
+		disp.AfterFunc(d, func() {
+			fired = append(fired, d)
+		})
+	}
+	stopped := disp.AfterFunc(300*time.Millisecond, func() {
+		t.Fatal("stopped timer fired")
+	})
+	stopped.Stop()
+
+	for tick := uint64(1); tick <= 1<<20+300; tick++ {
+		w.step()
+		for len(disp.ChanTimer) > 0 {
+			timer := <-disp.ChanTimer
+			if expire := timer.node.expire; expire != tick {
+				t.Fatalf("timer of tick %v fired at %v", expire, tick)
+			}
+			timer.Cb()
+		}
+	}
+	if len(fired) != len(delays) {
+		t.Fatalf("unexpected fired timers %v", fired)
+	}
+	for i := 1; i < len(fired); i++ {
+		if fired[i] < fired[i-1] {
+			t.Fatalf("unexpected order %v", fired)
+		}
+	}
+}
+
+func TestWheelRun(t *testing.T) {
+	w := NewWheel(time.Millisecond)
+	defer w.Close()
+	disp := NewWheelDispatcher(10, w)
+
+	start := time.Now()
+	disp.AfterFunc(20*time.Millisecond, func() {})
+	(<-disp.ChanTimer).Cb()
+	if cost := time.Since(start); cost < 20*time.Millisecond {
+		t.Fatalf("timer fired too early, %v", cost)
+	}
+}
+
+func benchmarkAfterFunc(b *testing.B, disp *Dispatcher) {
+	b.ReportAllocs()
+	ts := make([]*Timer, 1000)
+	for i := 0; i < b.N; i++ {
+		t := ts[i%len(ts)]
+		if t != nil {
+			t.Stop()
+		}
+		ts[i%len(ts)] = disp.AfterFunc(time.Duration(i%3600)*time.Second, func() {})
+	}
+	for _, t := range ts {
+		if t != nil {
+			t.Stop()
+		}
+	}
+}
+
+func BenchmarkAfterFunc(b *testing.B) {
+	benchmarkAfterFunc(b, NewDispatcher(10))
+}
+
+func BenchmarkWheelAfterFunc(b *testing.B) {
+	w := NewWheel(10 * time.Millisecond)
+	defer w.Close()
+	benchmarkAfterFunc(b, NewWheelDispatcher(10, w))
+}
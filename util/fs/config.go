@@ -47,11 +47,14 @@ func (pmi *ParserMixIn) CheckModify(path string) (bool, int64) {
 	return ts != pmi.lastModified[path], ts
 }
 
-//周期性监测文件变化，调用Parser的回调
-func WatchConfigFiles(parsers map[string]IConfigParser) {
+//周期性监测文件变化，调用Parser的回调，返回是否有配置重新加载
+func WatchConfigFiles(parsers map[string]IConfigParser) bool {
+	reloaded := false
 	for path, parser := range parsers {
 		if parser.ReloadConfig(path, false) {
 			log.Info("config %s reloaded", path)
+			reloaded = true
 		}
 	}
+	return reloaded
 }